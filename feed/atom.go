@@ -1,11 +1,17 @@
 package feed
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/xml"
-	"html"
+	"errors"
 	"io"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/ugorji/go-common/errorutil"
 )
 
 // NOTES:
@@ -13,96 +19,228 @@ import (
 //
 // Feed should have self, and must have alternate link.
 // Feed must have author, so it stays optional for entries. default to "-" if specified.
-// Entry must have alternate link.
+// Entry must have alternate link, unless it has content.
+// Enclosures are links with rel="enclosure".
+// Paging adds RFC 5005 links, and the fh:archive and fh:complete elements (in the history namespace).
+//
+// A summary or content is written with its type: text (also if the type is unset), html (escaped once)
+// or xhtml (as markup in a div). ValidateAtom checks that xhtml is well-formed,
+// since it is written as-is.
 //
 // Order of fields is important for entry/feedAtom, since xml marshals them in order.
 
-type authorAtom struct {
-	XMLName xml.Name `xml:"author"`
-	Name    string   `xml:"name,omitempty"`
+type personAtom struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+	URI   string `xml:"uri,omitempty"`
 }
 
-type summaryAtom struct {
-	XMLName xml.Name `xml:"summary"`
-	S       string   `xml:",chardata"`
-	Type    string   `xml:"type,attr"`
+// textAtom is a text construct (e.g. summary, content, rights).
+// xhtml is written as markup (in a div), while text and html are escaped.
+type textAtom struct {
+	Type string `xml:"type,attr"`
+	S    string `xml:",chardata"`
+	X    string `xml:",innerxml"`
 }
 
 type linkAtom struct {
 	XMLName xml.Name `xml:"link"`
 	Href    string   `xml:"href,attr"`
 	Rel     string   `xml:"rel,attr"`
-	Type    string   `xml:"type,attr"`
+	Type    string   `xml:"type,attr,omitempty"`
+	Title   string   `xml:"title,attr,omitempty"`
+	Length  string   `xml:"length,attr,omitempty"`
+}
+
+type categoryAtom struct {
+	Term string `xml:"term,attr"`
 }
 
 type entryAtom struct {
-	XMLName   xml.Name `xml:"entry"`
-	Id        string   `xml:"id"`
-	Title     string   `xml:"title"`
-	Author    *authorAtom
-	Published string `xml:"published"`
-	Updated   string `xml:"updated"`
-	Link      linkAtom
-	Summary   *summaryAtom
+	XMLName      xml.Name       `xml:"entry"`
+	Id           string         `xml:"id"`
+	Title        string         `xml:"title"`
+	Author       *personAtom    `xml:"author"`
+	Contributors []personAtom   `xml:"contributor"`
+	Published    string         `xml:"published"`
+	Updated      string         `xml:"updated"`
+	Links        []linkAtom     `xml:"link"`
+	Categories   []categoryAtom `xml:"category"`
+	Summary      *textAtom      `xml:"summary"`
+	Content      *textAtom      `xml:"content"`
+	Rights       *textAtom      `xml:"rights"`
 }
 
 type feedAtom struct {
-	XMLName   xml.Name    `xml:"feed"`
-	Ns        string      `xml:"xmlns,attr"`
-	Id        string      `xml:"id"`
-	Title     string      `xml:"title"`
-	Author    *authorAtom // pointer so it can be nil
-	Published string      `xml:"published"`
-	Updated   string      `xml:"updated"`
-	Links     []linkAtom
-	Entries   []*entryAtom
+	XMLName      xml.Name     `xml:"feed"`
+	Ns           string       `xml:"xmlns,attr"`
+	NsHistory    string       `xml:"xmlns:fh,attr,omitempty"`
+	Id           string       `xml:"id"`
+	Title        string       `xml:"title"`
+	Subtitle     string       `xml:"subtitle,omitempty"`
+	Author       *personAtom  `xml:"author"` // pointer so it can be nil
+	Contributors []personAtom `xml:"contributor"`
+	Published    string       `xml:"published"`
+	Updated      string       `xml:"updated"`
+	Rights       *textAtom    `xml:"rights"`
+	Archive      *struct{}    `xml:"fh:archive"`
+	Complete     *struct{}    `xml:"fh:complete"`
+	Links        []linkAtom
+	Entries      []*entryAtom
 }
 
-func toAtomEntry(e *Entry) *entryAtom {
+// ValidationError is a required Atom element which is missing from a feed.
+type ValidationError struct {
+	Where string // e.g. "entry 2"
+	Msg   string
+}
+
+func (x *ValidationError) Error() string {
+	return "feed: " + x.Where + ": " + x.Msg
+}
+
+// entryID returns the ID of the entry, else a tag URI derived from its link and publication date.
+// Without a link, it is a name-based urn:uuid derived from the title, date and text of the entry.
+func entryID(e *Entry) string {
+	if e.ID != "" {
+		return e.ID
+	}
+	if e.Link == "" {
+		h := sha1.Sum([]byte(e.Title + "\x00" + e.PubDate.Format(time.RFC3339Nano) + "\x00" + e.Desc + "\x00" + e.Content))
+		h[6] = h[6]&0x0f | 0x50 // version 5 (sha-1)
+		h[8] = h[8]&0x3f | 0x80 // rfc 4122 variant
+		x := hex.EncodeToString(h[:16])
+		return "urn:uuid:" + x[:8] + "-" + x[8:12] + "-" + x[12:16] + "-" + x[16:20] + "-" + x[20:]
+	}
 	idDate := e.PubDate.Format("2006-01-02")
 	id := "tag:" + e.Link + "," + idDate + ":/invalid.html"
 	if url, err := url.Parse(e.Link); err == nil {
 		id = "tag:" + url.Host + "," + idDate + ":" + url.Path
 	}
+	return id
+}
+
+const xhtmlDivOpen = `<div xmlns="http://www.w3.org/1999/xhtml">`
+
+// checkXHTML returns an error if the xhtml is not well-formed XML
+// (e.g. with html entities or unclosed tags), or closes its wrapping div.
+func checkXHTML(s string) error {
+	d := xml.NewDecoder(strings.NewReader(xhtmlDivOpen + s + `</div>`))
+	depth, closed := 0, false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if closed {
+			return errors.New("markup after the end of the div")
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+			closed = depth == 0
+		}
+	}
+}
+
+func toAtomText(s string, typ textType) *textAtom {
+	if s == "" {
+		return nil
+	}
+	switch typ {
+	case TextHtml:
+		return &textAtom{S: s, Type: "html"}
+	case TextXHtml:
+		return &textAtom{X: xhtmlDivOpen + s + `</div>`, Type: "xhtml"}
+	}
+	return &textAtom{S: s, Type: "text"}
+}
+
+func toAtomPersons(ps []Person) (xs []personAtom) {
+	for _, p := range ps {
+		xs = append(xs, personAtom{Name: p.Name, Email: p.Email, URI: p.URI})
+	}
+	return
+}
+
+func toAtomLinks(ls []Link) (xs []linkAtom) {
+	for _, l := range ls {
+		xs = append(xs, linkAtom{Href: l.Href, Rel: l.Rel, Type: l.Type, Title: l.Title})
+	}
+	return
+}
+
+func toAtomEntry(e *Entry) *entryAtom {
 	xe := entryAtom{
-		Title:     e.Title,
-		Link:      linkAtom{Href: e.Link, Rel: "alternate", Type: "text/html"},
-		Id:        id,
-		Published: e.PubDate.Format(time.RFC3339),
-		Updated:   e.LastMod.Format(time.RFC3339),
-	}
-	if e.Desc != "" {
-		switch e.DescType {
-		case TextPlain:
-			xe.Summary = &summaryAtom{S: e.Desc, Type: "text"}
-		case TextHtml:
-			xe.Summary = &summaryAtom{S: html.EscapeString(e.Desc), Type: "html"}
-		case TextXHtml:
-			xe.Summary = &summaryAtom{S: e.Desc, Type: "xhtml"}
+		Title:        e.Title,
+		Id:           entryID(e),
+		Published:    e.PubDate.Format(time.RFC3339),
+		Updated:      e.LastMod.Format(time.RFC3339),
+		Contributors: toAtomPersons(e.Contributors),
+		Summary:      toAtomText(e.Desc, e.DescType),
+		Content:      toAtomText(e.Content, e.ContentType),
+		Rights:       toAtomText(e.Rights, TextPlain),
+	}
+	if e.Link != "" {
+		xe.Links = append(xe.Links, linkAtom{Href: e.Link, Rel: "alternate", Type: "text/html"})
+	}
+	xe.Links = append(xe.Links, toAtomLinks(e.Links)...)
+	for _, x := range e.Enclosures {
+		l := linkAtom{Href: x.URL, Rel: "enclosure", Type: x.Type}
+		if x.Length > 0 {
+			l.Length = strconv.FormatInt(x.Length, 10)
 		}
+		xe.Links = append(xe.Links, l)
+	}
+	for _, c := range e.Categories {
+		xe.Categories = append(xe.Categories, categoryAtom{Term: c})
 	}
 	if e.Author != "" {
-		xe.Author = &authorAtom{Name: e.Author}
+		xe.Author = &personAtom{Name: e.Author}
 	}
 	return &xe
 }
 
 func toAtomFeed(f *Feed) *feedAtom {
 	xf := feedAtom{
-		Ns:        "http://www.w3.org/2005/Atom",
-		Title:     f.Title,
-		Id:        f.Link,
-		Published: f.PubDate.Format(time.RFC3339),
-		Updated:   f.LastMod.Format(time.RFC3339),
+		Ns:           "http://www.w3.org/2005/Atom",
+		Title:        f.Title,
+		Subtitle:     f.Desc,
+		Id:           f.ID,
+		Published:    f.PubDate.Format(time.RFC3339),
+		Updated:      f.LastMod.Format(time.RFC3339),
+		Contributors: toAtomPersons(f.Contributors),
+		Rights:       toAtomText(f.Rights, TextPlain),
+	}
+	if xf.Id == "" {
+		xf.Id = f.Link
 	}
 	xf.Links = append(xf.Links, linkAtom{Href: f.Link, Rel: "alternate", Type: "text/html"})
 	if f.SelfLink != "" {
 		xf.Links = append(xf.Links, linkAtom{Href: f.SelfLink, Rel: "self", Type: "application/atom+xml"})
 	}
+	xf.Links = append(xf.Links, toAtomLinks(f.Links)...)
+	if p := f.Paging; p != nil {
+		xf.Links = append(xf.Links, toAtomLinks(p.links())...)
+		if p.Archive {
+			xf.Archive = &struct{}{}
+		}
+		if p.Complete {
+			xf.Complete = &struct{}{}
+		}
+		if p.Archive || p.Complete {
+			xf.NsHistory = nsHistory
+		}
+	}
 	if f.Author == "" {
-		xf.Author = &authorAtom{Name: "-"}
+		xf.Author = &personAtom{Name: "-"}
 	} else {
-		xf.Author = &authorAtom{Name: f.Author}
+		xf.Author = &personAtom{Name: f.Author}
 	}
 	for _, e := range f.Entries {
 		xf.Entries = append(xf.Entries, toAtomEntry(e))
@@ -110,7 +248,57 @@ func toAtomFeed(f *Feed) *feedAtom {
 	return &xf
 }
 
+// ValidateAtom checks that the feed has the elements which Atom requires:
+// an ID (or Link), a title and an updated date for the feed and each entry,
+// an alternate link for entries without content, an href for every link,
+// and well-formed xhtml summaries and content.
+//
+// All the missing elements are reported, as an errorutil.Multi of *ValidationError.
+func (f *Feed) ValidateAtom() error {
+	var merr errorutil.Multi
+	check := func(ok bool, where, msg string) {
+		if !ok {
+			merr = append(merr, &ValidationError{Where: where, Msg: msg})
+		}
+	}
+	checkLinks := func(ls []Link, where string) {
+		for _, l := range ls {
+			check(l.Href != "", where, "link without href")
+		}
+	}
+	checkText := func(s string, typ textType, where, what string) {
+		if typ == TextXHtml && s != "" {
+			if err := checkXHTML(s); err != nil {
+				check(false, where, "malformed xhtml "+what+": "+err.Error())
+			}
+		}
+	}
+	check(f.ID != "" || f.Link != "", "feed", "missing id (or link)")
+	check(f.Link != "", "feed", "missing alternate link")
+	check(f.Title != "", "feed", "missing title")
+	check(!f.LastMod.IsZero(), "feed", "missing updated date")
+	checkLinks(f.Links, "feed")
+	for i, e := range f.Entries {
+		where := "entry " + strconv.Itoa(i+1)
+		check(e.ID != "" || e.Link != "", where, "missing id (or link)")
+		check(e.Title != "", where, "missing title")
+		check(!e.LastMod.IsZero(), where, "missing updated date")
+		check(e.Link != "" || e.Content != "", where, "missing alternate link (or content)")
+		checkLinks(e.Links, where)
+		checkText(e.Desc, e.DescType, where, "summary")
+		checkText(e.Content, e.ContentType, where, "content")
+		for _, x := range e.Enclosures {
+			check(x.URL != "", where, "enclosure without url")
+		}
+	}
+	return merr.NonNilError()
+}
+
+// ToAtom writes the feed in Atom format, after validating it (see ValidateAtom).
 func (f *Feed) ToAtom(w io.Writer, indent string) (err error) {
+	if err = f.ValidateAtom(); err != nil {
+		return
+	}
 	xf := toAtomFeed(f)
 	if _, err = io.WriteString(w, xml.Header); err != nil {
 		return
@@ -1,4 +1,10 @@
 /*
-Package feed supports Atom feeds.
+Package feed supports Atom, RSS 2.0 and JSON Feed 1.1 feeds.
+
+Parse reads Atom, RSS 1.0/2.0 and JSON Feed documents (auto-detecting the format) into a Feed.
+
+Merge combines the entries of several feeds (de-duplicated, sorted and truncated).
+Pages and Archives split a large feed into RFC 5005 paged or archived feeds,
+whose links are written by ToAtom.
 */
 package feed
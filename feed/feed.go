@@ -4,6 +4,8 @@ import (
 	"time"
 )
 
+// textType is the type of a text (e.g. Entry.DescType).
+// An unset (zero) textType is treated as TextPlain by every writer.
 type textType uint8
 
 const (
@@ -12,14 +14,27 @@ const (
 	TextXHtml
 )
 
+// isHTML returns true if the text is markup (html or xhtml).
+func (x textType) isHTML() bool {
+	return x == TextHtml || x == TextXHtml
+}
+
 type Feed struct {
 	Title    string
 	Link     string
 	SelfLink string
+	Desc     string // description (RSS) or subtitle; defaults to Title where required
 	PubDate  time.Time
 	LastMod  time.Time
 	Entries  []*Entry
 	Author   string
+	// ID is the unique, permanent identifier of the feed. If empty, the Link is used.
+	ID           string
+	Links        []Link // other links (besides Link and SelfLink)
+	Rights       string
+	Contributors []Person
+	// Paging holds the RFC 5005 links, if the feed is a page or archive (see Pages and Archives).
+	Paging *Paging
 }
 
 type Entry struct {
@@ -30,4 +45,38 @@ type Entry struct {
 	PubDate  time.Time
 	LastMod  time.Time
 	Author   string
+	// ID is the unique, permanent identifier (GUID) of the entry.
+	// If empty, one is derived from the Link and PubDate (or, without a Link, from
+	// the Title, PubDate and text), the same in every format.
+	ID         string
+	Categories []string
+	Enclosures []Enclosure
+	// Content is the full content (Desc is the summary).
+	Content      string
+	ContentType  textType
+	Links        []Link // other links (besides Link and the Enclosures)
+	Rights       string
+	Contributors []Person
+}
+
+// Link is a link to a related resource, e.g. with Rel "related" or "via".
+type Link struct {
+	Href  string
+	Rel   string
+	Type  string // MIME type
+	Title string
+}
+
+// Person is a contributor of a feed or entry.
+type Person struct {
+	Name  string
+	Email string
+	URI   string
+}
+
+// Enclosure is a file attached to an entry (e.g. a podcast episode).
+type Enclosure struct {
+	URL    string
+	Type   string // MIME type
+	Length int64  // size in bytes
 }
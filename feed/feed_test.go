@@ -0,0 +1,250 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/errorutil"
+	"github.com/ugorji/go-common/testutil"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func testFeed() *Feed {
+	t0 := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	return &Feed{
+		Title:    "Example Podcast",
+		Link:     "https://example.com/",
+		SelfLink: "https://example.com/feed",
+		Desc:     "News & episodes",
+		PubDate:  t0,
+		LastMod:  t0.Add(48 * time.Hour),
+		Author:   "Jane Doe",
+		ID:       "urn:uuid:0f6e1d2c-5b4a-4c3d-9e8f-7a6b5c4d3e2f",
+		Rights:   "Copyright 2020 Example",
+		Links:    []Link{{Href: "https://example.com/hub", Rel: "hub"}},
+		Contributors: []Person{
+			{Name: "Ed Itor", Email: "ed@example.com"},
+			{Name: "Pro Ducer", URI: "https://example.com/producer"},
+		},
+		Entries: []*Entry{
+			{
+				Title:        "Episode 2",
+				Link:         "https://example.com/ep/2",
+				Desc:         "<p>The <b>second</b> episode</p>",
+				DescType:     TextHtml,
+				PubDate:      t0.Add(24 * time.Hour),
+				LastMod:      t0.Add(48 * time.Hour),
+				ID:           "urn:uuid:7b1a9a8e-2c47-4ed4-b0c3-96b4e1f0a002",
+				Categories:   []string{"news", "audio"},
+				Enclosures:   []Enclosure{{URL: "https://example.com/ep/2.mp3", Type: "audio/mpeg", Length: 12345678}},
+				Content:      "<p>Full <i>show</i> notes</p>",
+				ContentType:  TextXHtml,
+				Links:        []Link{{Href: "https://example.com/ep/2/comments", Rel: "replies", Type: "text/html", Title: "Comments"}},
+				Rights:       "CC BY 4.0",
+				Contributors: []Person{{Name: "Guest Star"}},
+			},
+			{
+				Title:    "Episode 1",
+				Link:     "https://example.com/ep/1",
+				Desc:     "The first episode, 1 < 2",
+				DescType: TextPlain,
+				PubDate:  t0,
+				LastMod:  t0,
+				Author:   "John Roe",
+			},
+		},
+	}
+}
+
+// checkGolden compares the output to the golden file in testdata (or updates it, with -update).
+func checkGolden(t *testing.T, name string, b []byte) {
+	fpath := filepath.Join("testdata", name)
+	if *updateGolden {
+		testutil.CheckErr(t, os.WriteFile(fpath, b, 0644))
+		return
+	}
+	want, err := os.ReadFile(fpath)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, string(b), string(want), name)
+}
+
+func TestAtom(t *testing.T) {
+	var buf bytes.Buffer
+	testutil.CheckErr(t, testFeed().ToAtom(&buf, "  "))
+	checkGolden(t, "feed.atom", buf.Bytes())
+}
+
+func TestRSS(t *testing.T) {
+	var buf bytes.Buffer
+	testutil.CheckErr(t, testFeed().ToRSS(&buf, "  "))
+	checkGolden(t, "feed.rss", buf.Bytes())
+
+	var v struct {
+		Version string `xml:"version,attr"`
+		Channel struct {
+			Description string `xml:"description"`
+			Items       []struct {
+				Title string `xml:"title"`
+				Guid  string `xml:"guid"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	testutil.CheckErr(t, xml.Unmarshal(buf.Bytes(), &v))
+	testutil.CheckEqual(t, v.Version, "2.0", "version")
+	testutil.CheckEqual(t, v.Channel.Description, "News & episodes", "description")
+	testutil.CheckEqual(t, len(v.Channel.Items), 2, "items")
+	for _, it := range v.Channel.Items {
+		if it.Title == "" || it.Guid == "" {
+			t.Errorf("item without title or guid: %+v", it)
+		}
+	}
+}
+
+func TestJSONFeed(t *testing.T) {
+	var buf bytes.Buffer
+	testutil.CheckErr(t, testFeed().ToJSONFeed(&buf, "  "))
+	checkGolden(t, "feed.json", buf.Bytes())
+
+	var v struct {
+		Version string
+		Title   string
+		Items   []map[string]interface{}
+	}
+	testutil.CheckErr(t, json.Unmarshal(buf.Bytes(), &v))
+	testutil.CheckEqual(t, v.Version, "https://jsonfeed.org/version/1.1", "version")
+	testutil.CheckEqual(t, len(v.Items), 2, "items")
+	for _, it := range v.Items {
+		_, html := it["content_html"]
+		_, text := it["content_text"]
+		if it["id"] == nil || html == text {
+			t.Errorf("item without id, or without exactly one content: %v", it)
+		}
+	}
+}
+
+// TestFormatsConsistent checks that every format writes the same ID for an entry,
+// and treats an unset DescType as plain text.
+func TestFormatsConsistent(t *testing.T) {
+	date := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	f := &Feed{Title: "T", Link: "https://example.com/", LastMod: date, Entries: []*Entry{
+		{Title: "A", Link: "https://example.com/a", Desc: "a < b", PubDate: date, LastMod: date},
+		{Title: "B", Desc: "no id or link", PubDate: date, LastMod: date},
+	}}
+	id := entryID(f.Entries[0])
+	testutil.CheckEqual(t, id, "tag:example.com,2020-03-01:/a", "derived id")
+
+	var buf bytes.Buffer
+	testutil.CheckErr(t, f.ToRSS(&buf, ""))
+	var r struct {
+		Items []struct {
+			Description string `xml:"description"`
+			Guid        *struct {
+				IsPermaLink string `xml:"isPermaLink,attr"`
+				S           string `xml:",chardata"`
+			} `xml:"guid"`
+		} `xml:"channel>item"`
+	}
+	testutil.CheckErr(t, xml.Unmarshal(buf.Bytes(), &r))
+	testutil.CheckEqual(t, r.Items[0].Description, "a &lt; b", "rss: plain description is escaped html")
+	testutil.CheckEqual(t, []string{r.Items[0].Guid.S, r.Items[0].Guid.IsPermaLink}, []string{id, "false"}, "rss: guid")
+	idB := entryID(f.Entries[1])
+	testutil.CheckEqual(t, strings.HasPrefix(idB, "urn:uuid:") && len(idB) == 45, true, "derived id without link: "+idB)
+	testutil.CheckEqual(t, r.Items[1].Guid.S, idB, "rss: guid without id or link")
+	f2 := *f.Entries[1]
+	f2.Title = "C"
+	testutil.CheckEqual(t, entryID(&f2) != idB, true, "derived ids differ")
+
+	buf.Reset()
+	testutil.CheckErr(t, f.ToJSONFeed(&buf, ""))
+	var j struct {
+		Items []map[string]interface{}
+	}
+	testutil.CheckErr(t, json.Unmarshal(buf.Bytes(), &j))
+	testutil.CheckEqual(t, []interface{}{j.Items[0]["id"], j.Items[0]["content_text"]}, []interface{}{id, "a < b"}, "json: id and text")
+	testutil.CheckEqual(t, j.Items[1]["id"], idB, "json: id without id or link")
+
+	f.Entries = f.Entries[:1]
+	buf.Reset()
+	testutil.CheckErr(t, f.ToAtom(&buf, ""))
+	var a struct {
+		Entries []struct {
+			ID      string `xml:"id"`
+			Summary struct {
+				Type string `xml:"type,attr"`
+				S    string `xml:",chardata"`
+			} `xml:"summary"`
+		} `xml:"entry"`
+	}
+	testutil.CheckErr(t, xml.Unmarshal(buf.Bytes(), &a))
+	testutil.CheckEqual(t, []string{a.Entries[0].ID, a.Entries[0].Summary.Type, a.Entries[0].Summary.S}, []string{id, "text", "a < b"}, "atom: id and text")
+}
+
+func TestValidateAtom(t *testing.T) {
+	testutil.CheckErr(t, testFeed().ValidateAtom())
+	f := testFeed()
+	f.Title = ""
+	f.Entries[0].LastMod = time.Time{}
+	f.Entries[1].Link = ""
+	f.Entries[1].Links = []Link{{Rel: "related"}}
+	err := f.ToAtom(new(bytes.Buffer), "")
+	merr, ok := err.(errorutil.Multi)
+	testutil.CheckEqual(t, ok, true, "multi error")
+	var msgs []string
+	for _, x := range merr {
+		msgs = append(msgs, x.Error())
+	}
+	testutil.CheckEqual(t, msgs, []string{
+		"feed: feed: missing title",
+		"feed: entry 1: missing updated date",
+		"feed: entry 2: missing id (or link)",
+		"feed: entry 2: missing alternate link (or content)",
+		"feed: entry 2: link without href",
+	}, "validation errors")
+
+	// xhtml is written as-is, so it must be well-formed
+	for _, x := range []struct {
+		s  string
+		ok bool
+	}{
+		{"a <b>b</b> &amp; <br/> c", true},
+		{"a &nbsp; <br> b", false},
+		{"<p>unclosed", false},
+		{"a</div><script/><div>b", false},
+	} {
+		f = testFeed()
+		f.Entries[0].Desc, f.Entries[0].DescType = x.s, TextXHtml
+		testutil.CheckEqual(t, f.ValidateAtom() == nil, x.ok, "xhtml summary: "+x.s)
+		f = testFeed()
+		f.Entries[0].Content, f.Entries[0].ContentType = x.s, TextXHtml
+		testutil.CheckEqual(t, f.ValidateAtom() == nil, x.ok, "xhtml content: "+x.s)
+	}
+}
+
+// TestAtomText pins how summaries are written: an unset type is text, and html is escaped once.
+func TestAtomText(t *testing.T) {
+	for _, x := range []struct {
+		typ             textType
+		name, desc, out string
+	}{
+		{0, "text", "1 < 2", `<summary type="text">1 &lt; 2</summary>`},
+		{TextHtml, "html", "<p>a &amp; b</p>", `<summary type="html">&lt;p&gt;a &amp;amp; b&lt;/p&gt;</summary>`},
+		{TextXHtml, "xhtml", "<p>a</p>", `<summary type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>a</p></div></summary>`},
+	} {
+		f := testFeed()
+		f.Entries[0].Desc, f.Entries[0].DescType = x.desc, x.typ
+		var buf bytes.Buffer
+		testutil.CheckErr(t, f.ToAtom(&buf, ""))
+		testutil.CheckEqual(t, strings.Contains(buf.String(), x.out), true, x.name+" summary")
+		p, err := Parse(&buf)
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, p.Feed.Entries[0].Desc, x.desc, x.name+" round trip")
+	}
+}
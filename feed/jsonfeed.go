@@ -0,0 +1,109 @@
+package feed
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// NOTES:
+// JSON Feed 1.1 requires version, title and items on the feed, and id on an item.
+// An item must have content_html or content_text: it is the Content (and the Desc is the summary),
+// else the Desc. An empty content_text is written if it has neither.
+
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+
+type authorJSON struct {
+	Name string `json:"name,omitempty"`
+}
+
+type attachmentJSON struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+type itemJSON struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url,omitempty"`
+	Title         string           `json:"title,omitempty"`
+	ContentHTML   *string          `json:"content_html,omitempty"`
+	ContentText   *string          `json:"content_text,omitempty"`
+	Summary       string           `json:"summary,omitempty"`
+	DatePublished string           `json:"date_published,omitempty"`
+	DateModified  string           `json:"date_modified,omitempty"`
+	Authors       []authorJSON     `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+	Attachments   []attachmentJSON `json:"attachments,omitempty"`
+}
+
+type feedJSON struct {
+	Version     string       `json:"version"`
+	Title       string       `json:"title"`
+	HomePageURL string       `json:"home_page_url,omitempty"`
+	FeedURL     string       `json:"feed_url,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Authors     []authorJSON `json:"authors,omitempty"`
+	Items       []*itemJSON  `json:"items"`
+}
+
+func jsonDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func toJSONItem(e *Entry) *itemJSON {
+	xe := itemJSON{
+		ID:            entryID(e),
+		URL:           e.Link,
+		Title:         e.Title,
+		DatePublished: jsonDate(e.PubDate),
+		DateModified:  jsonDate(e.LastMod),
+		Tags:          e.Categories,
+	}
+	content, typ := e.Desc, e.DescType
+	if e.Content != "" {
+		content, typ = e.Content, e.ContentType
+		xe.Summary = e.Desc
+	}
+	if !typ.isHTML() || content == "" {
+		xe.ContentText = &content
+	} else {
+		xe.ContentHTML = &content
+	}
+	if e.Author != "" {
+		xe.Authors = []authorJSON{{Name: e.Author}}
+	}
+	for _, x := range e.Enclosures {
+		xe.Attachments = append(xe.Attachments, attachmentJSON{URL: x.URL, MimeType: x.Type, SizeInBytes: x.Length})
+	}
+	return &xe
+}
+
+func toJSONFeed(f *Feed) *feedJSON {
+	xf := feedJSON{
+		Version:     jsonFeedVersion,
+		Title:       f.Title,
+		HomePageURL: f.Link,
+		FeedURL:     f.SelfLink,
+		Description: f.Desc,
+		Items:       []*itemJSON{},
+	}
+	if f.Author != "" {
+		xf.Authors = []authorJSON{{Name: f.Author}}
+	}
+	for _, e := range f.Entries {
+		xf.Items = append(xf.Items, toJSONItem(e))
+	}
+	return &xf
+}
+
+// ToJSONFeed writes the feed in JSON Feed 1.1 format.
+func (f *Feed) ToJSONFeed(w io.Writer, indent string) (err error) {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	return enc.Encode(toJSONFeed(f))
+}
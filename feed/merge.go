@@ -0,0 +1,103 @@
+package feed
+
+import (
+	"sort"
+)
+
+// Dedup removes the duplicate entries, i.e. entries with the same ID or the same Link.
+// Duplicates are transitive: an entry sharing its ID with one entry and its Link
+// with another makes all three duplicates.
+// Of duplicates, the most recently updated one is kept, at the position of the first one.
+func Dedup(entries []*Entry) []*Entry {
+	seen := make(map[string]int) // "id:" + ID or "link:" + Link -> index in es
+	es := make([]*Entry, 0, len(entries))
+	var parent []int // index in es -> index of the group it was merged into
+	find := func(i int) int {
+		for parent[i] != i {
+			i = parent[i]
+		}
+		return i
+	}
+	var merged bool
+	for _, e := range entries {
+		var keys []string
+		if e.ID != "" {
+			keys = append(keys, "id:"+e.ID)
+		}
+		if e.Link != "" {
+			keys = append(keys, "link:"+e.Link)
+		}
+		i := -1
+		for _, k := range keys {
+			j, dup := seen[k]
+			if !dup {
+				continue
+			}
+			j = find(j)
+			switch {
+			case i == -1:
+				i = j
+			case j != i:
+				// e joins two groups: merge the later one into the first one
+				if j < i {
+					i, j = j, i
+				}
+				parent[j] = i
+				if es[j].LastMod.After(es[i].LastMod) {
+					es[i] = es[j]
+				}
+				es[j] = nil
+				merged = true
+			}
+		}
+		if i == -1 {
+			i = len(es)
+			es = append(es, e)
+			parent = append(parent, i)
+		} else if e.LastMod.After(es[i].LastMod) {
+			es[i] = e
+		}
+		for _, k := range keys {
+			seen[k] = i
+		}
+	}
+	if merged {
+		es2 := es[:0]
+		for _, e := range es {
+			if e != nil {
+				es2 = append(es2, e)
+			}
+		}
+		es = es2
+	}
+	return es
+}
+
+// SortByUpdated sorts the entries by updated time (LastMod), most recent first.
+// Entries updated at the same time are sorted by PubDate, else kept in order.
+func SortByUpdated(entries []*Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		ei, ej := entries[i], entries[j]
+		if !ei.LastMod.Equal(ej.LastMod) {
+			return ei.LastMod.After(ej.LastMod)
+		}
+		return ei.PubDate.After(ej.PubDate)
+	})
+}
+
+// Merge returns the entries of all the feeds, de-duplicated (see Dedup),
+// sorted by updated time (see SortByUpdated) and truncated to max entries (if max > 0).
+func Merge(max int, feeds ...*Feed) []*Entry {
+	var es []*Entry
+	for _, f := range feeds {
+		if f != nil {
+			es = append(es, f.Entries...)
+		}
+	}
+	es = Dedup(es)
+	SortByUpdated(es)
+	if max > 0 && len(es) > max {
+		es = es[:max]
+	}
+	return es
+}
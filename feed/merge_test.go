@@ -0,0 +1,52 @@
+package feed
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func testEntryTitles(es []*Entry) (ss []string) {
+	for _, e := range es {
+		ss = append(ss, e.Title)
+	}
+	return
+}
+
+func TestMerge(t *testing.T) {
+	t0 := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	day := func(n int) time.Time { return t0.Add(time.Duration(n) * 24 * time.Hour) }
+	f1 := &Feed{Entries: []*Entry{
+		{Title: "a", ID: "id-a", Link: "https://example.com/a", LastMod: day(1)},
+		{Title: "b", Link: "https://example.com/b", LastMod: day(3)},
+		{Title: "c", ID: "id-c", LastMod: day(2), PubDate: day(1)},
+	}}
+	f2 := &Feed{Entries: []*Entry{
+		{Title: "a2", ID: "id-a", LastMod: day(5)},                    // same ID as a, more recent
+		{Title: "b2", Link: "https://example.com/b", LastMod: day(0)}, // same link as b, older
+		{Title: "d", ID: "id-d", LastMod: day(2), PubDate: day(2)},
+		{Title: "e", Link: "https://example.com/e", LastMod: day(4)},
+	}}
+
+	testutil.CheckEqual(t, testEntryTitles(Dedup(append(f1.Entries, f2.Entries...))), []string{"a2", "b", "c", "d", "e"}, "dedup")
+	testutil.CheckEqual(t, testEntryTitles(Merge(0, f1, nil, f2)), []string{"a2", "e", "b", "d", "c"}, "merge")
+	testutil.CheckEqual(t, testEntryTitles(Merge(3, f1, f2)), []string{"a2", "e", "b"}, "merge truncated")
+	testutil.CheckEqual(t, len(f1.Entries)+len(f2.Entries), 7, "feeds unchanged")
+}
+
+func TestDedupAcrossKeys(t *testing.T) {
+	t0 := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	day := func(n int) time.Time { return t0.Add(time.Duration(n) * 24 * time.Hour) }
+	es := []*Entry{
+		{Title: "a", ID: "id-a", LastMod: day(1)},
+		{Title: "b", Link: "https://example.com/b", LastMod: day(3)},
+		{Title: "c", ID: "id-c", LastMod: day(0)},
+		// same ID as a, same link as b: a, b and ab are all duplicates
+		{Title: "ab", ID: "id-a", Link: "https://example.com/b", LastMod: day(2)},
+		{Title: "b2", Link: "https://example.com/b", LastMod: day(0)},
+	}
+	testutil.CheckEqual(t, testEntryTitles(Dedup(es)), []string{"b", "c"}, "dedup across keys")
+	es[3].LastMod = day(4)
+	testutil.CheckEqual(t, testEntryTitles(Dedup(es)), []string{"ab", "c"}, "dedup across keys, most recent")
+}
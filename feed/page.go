@@ -0,0 +1,149 @@
+package feed
+
+// NOTES:
+// RFC 5005 defines paged feeds (first/last/next/previous links), where pages may change,
+// and archived feeds, where the subscription (current) document links to stable archive
+// documents (prev-archive/next-archive/current links, and an fh:archive element).
+//
+// Archives are filled from the oldest entries, so that they never change once full:
+// the current document has the newest 1 to size entries.
+
+const nsHistory = "http://purl.org/syndication/history/1.0"
+
+// Paging holds the RFC 5005 links of a paged or archived feed. ToAtom writes them.
+type Paging struct {
+	// paged feed
+	First    string
+	Last     string
+	Next     string
+	Previous string
+	// archived feed
+	Current     string
+	PrevArchive string
+	NextArchive string
+	Archive     bool // the document is an archive (fh:archive)
+	Complete    bool // the document has all the entries of the feed (fh:complete)
+}
+
+func (x *Paging) links() (ls []Link) {
+	for _, l := range [...]Link{
+		{Rel: "first", Href: x.First},
+		{Rel: "last", Href: x.Last},
+		{Rel: "next", Href: x.Next},
+		{Rel: "previous", Href: x.Previous},
+		{Rel: "current", Href: x.Current},
+		{Rel: "prev-archive", Href: x.PrevArchive},
+		{Rel: "next-archive", Href: x.NextArchive},
+	} {
+		if l.Href != "" {
+			l.Type = "application/atom+xml"
+			ls = append(ls, l)
+		}
+	}
+	return
+}
+
+// set sets the link with the rel (returning false if it is not a paging link).
+func (x *Paging) set(rel, href string) bool {
+	var p *string
+	switch rel {
+	case "first":
+		p = &x.First
+	case "last":
+		p = &x.Last
+	case "next":
+		p = &x.Next
+	case "previous", "prev":
+		p = &x.Previous
+	case "current":
+		p = &x.Current
+	case "prev-archive":
+		p = &x.PrevArchive
+	case "next-archive":
+		p = &x.NextArchive
+	default:
+		return false
+	}
+	*p = href
+	return true
+}
+
+// page returns a copy of the feed, with the entries and self link of a page.
+// It is updated when its most recent entry is (or when the feed is, if it has no entry).
+func (f *Feed) page(entries []*Entry, self string, p *Paging) *Feed {
+	g := *f
+	g.Entries = entries
+	g.SelfLink = self
+	g.Paging = p
+	if len(entries) > 0 {
+		g.LastMod = entries[0].LastMod
+		for _, e := range entries[1:] {
+			if e.LastMod.After(g.LastMod) {
+				g.LastMod = e.LastMod
+			}
+		}
+	}
+	return &g
+}
+
+// Pages splits the entries of the feed (in order) into pages of size entries,
+// linked as an RFC 5005 paged feed. pageURL returns the URL of page n (from 1).
+//
+// There is always at least one page.
+func (f *Feed) Pages(size int, pageURL func(n int) string) (pages []*Feed) {
+	if size < 1 {
+		size = 1
+	}
+	num := (len(f.Entries) + size - 1) / size
+	if num == 0 {
+		num = 1
+	}
+	for n := 1; n <= num; n++ {
+		p := &Paging{First: pageURL(1), Last: pageURL(num)}
+		if n > 1 {
+			p.Previous = pageURL(n - 1)
+		}
+		if n < num {
+			p.Next = pageURL(n + 1)
+		}
+		end := n * size
+		if end > len(f.Entries) {
+			end = len(f.Entries)
+		}
+		pages = append(pages, f.page(f.Entries[(n-1)*size:end], pageURL(n), p))
+	}
+	return
+}
+
+// Archives splits the entries of the feed (newest first, see SortByUpdated) into
+// an RFC 5005 archived feed: the current (subscription) document with the newest entries,
+// and archives of size entries (oldest first). archiveURL returns the URL of archive n (from 1).
+//
+// The current document keeps the self link of the feed, which archives link to as current.
+func (f *Feed) Archives(size int, archiveURL func(n int) string) (current *Feed, archives []*Feed) {
+	if size < 1 {
+		size = 1
+	}
+	es := f.Entries
+	num := 0
+	if len(es) > 0 {
+		num = (len(es) - 1) / size
+	}
+	// archive n has the entries es[len(es)-n*size : len(es)-(n-1)*size]
+	for n := 1; n <= num; n++ {
+		p := &Paging{Archive: true, Current: f.SelfLink}
+		if n > 1 {
+			p.PrevArchive = archiveURL(n - 1)
+		}
+		if n < num {
+			p.NextArchive = archiveURL(n + 1)
+		}
+		archives = append(archives, f.page(es[len(es)-n*size:len(es)-(n-1)*size], archiveURL(n), p))
+	}
+	p := new(Paging)
+	if num > 0 {
+		p.PrevArchive = archiveURL(num)
+	}
+	current = f.page(es[:len(es)-num*size], f.SelfLink, p)
+	return
+}
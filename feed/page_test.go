@@ -0,0 +1,85 @@
+package feed
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func testPagedFeed(n int) *Feed {
+	t0 := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	f := &Feed{Title: "Archive", Link: "https://example.com/", SelfLink: "https://example.com/feed", LastMod: t0}
+	for i := n; i > 0; i-- {
+		d := t0.Add(time.Duration(i) * time.Hour)
+		f.Entries = append(f.Entries, &Entry{Title: strconv.Itoa(i), Link: "https://example.com/" + strconv.Itoa(i), PubDate: d, LastMod: d})
+	}
+	return f
+}
+
+func testURL(prefix string) func(int) string {
+	return func(n int) string { return "https://example.com/" + prefix + "/" + strconv.Itoa(n) }
+}
+
+func TestPages(t *testing.T) {
+	pages := testPagedFeed(7).Pages(3, testURL("page"))
+	testutil.CheckEqual(t, len(pages), 3, "pages")
+	testutil.CheckEqual(t, testEntryTitles(pages[1].Entries), []string{"4", "3", "2"}, "page 2")
+	testutil.CheckEqual(t, *pages[1].Paging, Paging{
+		First:    "https://example.com/page/1",
+		Last:     "https://example.com/page/3",
+		Next:     "https://example.com/page/3",
+		Previous: "https://example.com/page/1",
+	}, "page 2 links")
+	testutil.CheckEqual(t, pages[1].SelfLink, "https://example.com/page/2", "page 2 self")
+	testutil.CheckEqual(t, pages[1].LastMod, pages[1].Entries[0].LastMod, "page 2 updated")
+	testutil.CheckEqual(t, []string{pages[0].Paging.Previous, pages[2].Paging.Next}, []string{"", ""}, "ends")
+	testutil.CheckEqual(t, len(testPagedFeed(0).Pages(3, testURL("page"))), 1, "empty feed")
+}
+
+func TestArchives(t *testing.T) {
+	f := testPagedFeed(7)
+	current, archives := f.Archives(3, testURL("archive"))
+	testutil.CheckEqual(t, testEntryTitles(current.Entries), []string{"7"}, "current")
+	testutil.CheckEqual(t, *current.Paging, Paging{PrevArchive: "https://example.com/archive/2"}, "current links")
+	testutil.CheckEqual(t, current.SelfLink, f.SelfLink, "current self")
+	testutil.CheckEqual(t, len(archives), 2, "archives")
+	testutil.CheckEqual(t, testEntryTitles(archives[0].Entries), []string{"3", "2", "1"}, "archive 1")
+	testutil.CheckEqual(t, testEntryTitles(archives[1].Entries), []string{"6", "5", "4"}, "archive 2")
+	testutil.CheckEqual(t, *archives[0].Paging, Paging{
+		Archive:     true,
+		Current:     "https://example.com/feed",
+		NextArchive: "https://example.com/archive/2",
+	}, "archive 1 links")
+	testutil.CheckEqual(t, *archives[1].Paging, Paging{
+		Archive:     true,
+		Current:     "https://example.com/feed",
+		PrevArchive: "https://example.com/archive/1",
+	}, "archive 2 links")
+
+	// archives are stable: a full current document
+	current, archives = testPagedFeed(9).Archives(3, testURL("archive"))
+	testutil.CheckEqual(t, testEntryTitles(current.Entries), []string{"9", "8", "7"}, "full current")
+	testutil.CheckEqual(t, testEntryTitles(archives[0].Entries), []string{"3", "2", "1"}, "archive 1 unchanged")
+
+	var buf bytes.Buffer
+	testutil.CheckErr(t, archives[1].ToAtom(&buf, "  "))
+	s := buf.String()
+	for _, x := range []string{
+		`xmlns:fh="http://purl.org/syndication/history/1.0"`,
+		`<fh:archive></fh:archive>`,
+		`<link href="https://example.com/feed" rel="current" type="application/atom+xml"></link>`,
+		`<link href="https://example.com/archive/1" rel="prev-archive" type="application/atom+xml"></link>`,
+	} {
+		if !strings.Contains(s, x) {
+			t.Errorf("missing %s in:\n%s", x, s)
+		}
+	}
+	p, err := Parse(&buf)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, *p.Feed.Paging, *archives[1].Paging, "parsed paging")
+	testutil.CheckEqual(t, len(p.Feed.Links), 0, "paging links not in links")
+}
@@ -0,0 +1,292 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ugorji/go-common/errorutil"
+)
+
+// NOTES:
+// Parsing is lenient: it only fails if the format cannot be detected, or the document cannot be decoded.
+// Other problems (e.g. malformed dates, unknown encodings) are reported as issues, and the field is skipped.
+//
+// Invalid UTF-8 (in a document declared or defaulting to UTF-8) is decoded as Windows-1252,
+// which is the common cause (and a superset of ISO-8859-1 for printable characters).
+
+// Format is the format of a parsed feed.
+type Format uint8
+
+const (
+	FormatAtom Format = iota + 1
+	FormatRSS1
+	FormatRSS2
+	FormatJSONFeed
+)
+
+var formatNames = [...]string{"", "atom", "rss1", "rss2", "jsonfeed"}
+
+func (x Format) String() string {
+	if int(x) < len(formatNames) {
+		return formatNames[x]
+	}
+	return "unknown"
+}
+
+// UnknownFormatErr is returned by Parse if the document is not a feed.
+var UnknownFormatErr = errorutil.String("feed: unknown feed format")
+
+const (
+	nsAtom = "http://www.w3.org/2005/Atom"
+	nsRDF  = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// ParseIssue is a non-fatal problem found while parsing a feed.
+type ParseIssue struct {
+	Where string // e.g. "entry 2: pubDate"
+	Msg   string
+}
+
+func (x *ParseIssue) Error() string {
+	return "feed: " + x.Where + ": " + x.Msg
+}
+
+// Parsed is the result of parsing a feed.
+type Parsed struct {
+	Feed   *Feed
+	Format Format
+	// Issues are the non-fatal problems found, in document order.
+	Issues errorutil.Multi
+}
+
+func (p *Parsed) issue(where, msg string) {
+	p.Issues = append(p.Issues, &ParseIssue{Where: where, Msg: msg})
+}
+
+// Parse reads an Atom, RSS 1.0, RSS 2.0 (or 0.9x) or JSON Feed document,
+// auto-detecting its format, into a Feed.
+//
+// Missing updated (or published) dates default to the other one.
+func Parse(r io.Reader) (p *Parsed, err error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")) // BOM
+	b = bytes.TrimLeft(b, " \t\r\n")
+	p = new(Parsed)
+	if len(b) > 0 && b[0] == '{' {
+		p.Format = FormatJSONFeed
+		err = p.parseJSON(b)
+	} else {
+		err = p.parseXML(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defaultDates(p.Feed)
+	return
+}
+
+func defaultDates(f *Feed) {
+	fix := func(pub, mod *time.Time) {
+		if pub.IsZero() {
+			*pub = *mod
+		} else if mod.IsZero() {
+			*mod = *pub
+		}
+	}
+	fix(&f.PubDate, &f.LastMod)
+	for _, e := range f.Entries {
+		fix(&e.PubDate, &e.LastMod)
+	}
+}
+
+func (p *Parsed) parseXML(b []byte) (err error) {
+	if !utf8.Valid(b) {
+		if enc := xmlEncoding(b); enc == "" || isUTF8(enc) {
+			p.issue("document", "invalid UTF-8: decoded as windows-1252")
+			b = decode8bit(b, &cp1252)
+		}
+	}
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	dec.Strict = false
+	dec.Entity = xml.HTMLEntity
+	dec.CharsetReader = p.charsetReader
+	for {
+		var tok xml.Token
+		if tok, err = dec.Token(); err != nil {
+			if err == io.EOF {
+				err = UnknownFormatErr
+			}
+			return
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch {
+		case se.Name.Local == "feed" && (se.Name.Space == nsAtom || se.Name.Space == ""):
+			p.Format = FormatAtom
+			var x atomFeedIn
+			if err = dec.DecodeElement(&x, &se); err == nil {
+				p.Feed = x.feed(p)
+			}
+		case se.Name.Local == "rss":
+			p.Format = FormatRSS2
+			var x rss2In
+			if err = dec.DecodeElement(&x, &se); err == nil {
+				p.Feed = x.feed(p)
+			}
+		case se.Name.Local == "RDF" && se.Name.Space == nsRDF:
+			p.Format = FormatRSS1
+			var x rss1In
+			if err = dec.DecodeElement(&x, &se); err == nil {
+				p.Feed = x.feed(p)
+			}
+		default:
+			err = UnknownFormatErr
+		}
+		return
+	}
+}
+
+// xmlEncoding returns the encoding in the XML declaration (if any).
+func xmlEncoding(b []byte) string {
+	if !bytes.HasPrefix(b, []byte("<?xml")) {
+		return ""
+	}
+	decl := b
+	if i := bytes.Index(b, []byte("?>")); i >= 0 {
+		decl = b[:i]
+	}
+	i := bytes.Index(decl, []byte("encoding="))
+	if i < 0 || i+len("encoding=")+1 >= len(decl) {
+		return ""
+	}
+	s := decl[i+len("encoding="):]
+	if j := bytes.IndexByte(s[1:], s[0]); j >= 0 {
+		return string(s[1 : j+1])
+	}
+	return ""
+}
+
+func isUTF8(enc string) bool {
+	enc = strings.ToLower(enc)
+	return enc == "utf-8" || enc == "utf8"
+}
+
+// charsetReader supports the single-byte encodings common in feeds.
+// Unknown encodings are reported, and read as UTF-8.
+func (p *Parsed) charsetReader(label string, input io.Reader) (r io.Reader, err error) {
+	var table *[256]rune
+	switch strings.ToLower(label) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1", "l1":
+		table = &latin1
+	case "windows-1252", "cp1252":
+		table = &cp1252
+	default:
+		p.issue("document", "unsupported encoding "+label+": read as utf-8")
+		return input, nil
+	}
+	b, err := io.ReadAll(input)
+	if err != nil {
+		return
+	}
+	return bytes.NewReader(decode8bit(b, table)), nil
+}
+
+var latin1, cp1252 [256]rune
+
+func init() {
+	for i := range latin1 {
+		latin1[i] = rune(i)
+		cp1252[i] = rune(i)
+	}
+	for i, c := range []rune("€\u0081‚ƒ„…†‡ˆ‰Š‹Œ\u008dŽ\u008f\u0090‘’“”•–—˜™š›œ\u009džŸ") {
+		cp1252[0x80+i] = c
+	}
+}
+
+func decode8bit(b []byte, table *[256]rune) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(b) + len(b)/4)
+	for _, c := range b {
+		buf.WriteRune(table[c])
+	}
+	return buf.Bytes()
+}
+
+// dateLayouts are tried in order by parseDate.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"Mon, 2 Jan 2006 15:04 MST",
+	"Mon, 2 Jan 06 15:04:05 -0700",
+	"Mon, 2 Jan 06 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.ANSIC,
+	time.UnixDate,
+}
+
+// parseDate parses s in any of the common feed date formats.
+// Dates without a time zone are in UTC.
+func parseDate(s string) (t time.Time, ok bool) {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" {
+		return
+	}
+	try := func(s string) bool {
+		for _, layout := range dateLayouts {
+			var err error
+			if t, err = time.Parse(layout, s); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+	if try(s) {
+		return t, true
+	}
+	// tolerate a wrong or non-english day name (e.g. "Tues, 2 Jan 2006 ...")
+	if i := strings.IndexByte(s, ','); i >= 0 && try(strings.TrimSpace(s[i+1:])) {
+		return t, true
+	}
+	return
+}
+
+// date parses s (see parseDate), reporting an issue if it is malformed.
+func (p *Parsed) date(where, s string) (t time.Time) {
+	t, ok := parseDate(s)
+	if !ok && strings.TrimSpace(s) != "" {
+		p.issue(where, "malformed date: "+s)
+	}
+	return
+}
+
+// textType maps an Atom text type (or MIME type) to a textType.
+func textTypeOf(typ string) textType {
+	switch strings.ToLower(strings.TrimSpace(typ)) {
+	case "html", "text/html":
+		return TextHtml
+	case "xhtml", "application/xhtml+xml":
+		return TextXHtml
+	}
+	return TextPlain
+}
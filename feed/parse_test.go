@@ -0,0 +1,180 @@
+package feed
+
+import (
+	"bytes"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func testParseFile(t *testing.T, name string, format Format) *Parsed {
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	testutil.CheckErr(t, err)
+	p, err := Parse(bytes.NewReader(b))
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, p.Format, format, name+": format")
+	for _, x := range p.Issues {
+		testutil.Log(t, "%s: issue: %v", name, x)
+	}
+	return p
+}
+
+func testIssues(p *Parsed) (ws []string) {
+	for _, x := range p.Issues {
+		ws = append(ws, x.(*ParseIssue).Where)
+	}
+	return
+}
+
+func TestParseRSS2(t *testing.T) {
+	p := testParseFile(t, "rss2-latin1.xml", FormatRSS2)
+	f := p.Feed
+	testutil.CheckEqual(t, f.Title, "Café News", "title")
+	testutil.CheckEqual(t, f.Desc, "All about cafés and more", "desc")
+	testutil.CheckEqual(t, []string{f.Link, f.SelfLink}, []string{"https://example.org/", "https://example.org/rss"}, "links")
+	testutil.CheckEqual(t, f.Author, "editor@example.org (Ed)", "author")
+	testutil.CheckEqual(t, f.LastMod.Equal(time.Date(2020, 3, 3, 10, 0, 0, 0, time.UTC)), true, "lastmod")
+	testutil.CheckEqual(t, len(f.Entries), 2, "entries")
+
+	e := f.Entries[0]
+	testutil.CheckEqual(t, e.ID, "", "permalink guid is not an ID")
+	testutil.CheckEqual(t, e.Desc, "<p>We are <b>open</b></p>", "desc")
+	testutil.CheckEqual(t, e.DescType, TextHtml, "desc type")
+	testutil.CheckEqual(t, e.Author, "Ana", "author")
+	testutil.CheckEqual(t, e.Categories, []string{"news", "cafe"}, "categories")
+	testutil.CheckEqual(t, e.PubDate.Equal(time.Date(2020, 3, 2, 8, 30, 0, 0, time.UTC)), true, "pubdate")
+	testutil.CheckEqual(t, e.LastMod, e.PubDate, "lastmod defaults to pubdate")
+	testutil.CheckEqual(t, e.Enclosures, []Enclosure{{URL: "https://example.org/opening.mp3", Type: "audio/mpeg"}}, "enclosures")
+
+	e = f.Entries[1]
+	testutil.CheckEqual(t, []string{e.ID, e.Link}, []string{"menu-1", "https://example.org/menu"}, "guid")
+	testutil.CheckEqual(t, []string{e.Desc, e.Content}, []string{"", "<ul><li>Crêpe</li></ul>"}, "content:encoded")
+	testutil.CheckEqual(t, e.PubDate.IsZero(), true, "malformed pubdate")
+
+	testutil.CheckEqual(t, testIssues(p), []string{"entry 1: enclosure", "entry 2: pubDate"}, "issues")
+}
+
+func TestParseRSS1(t *testing.T) {
+	p := testParseFile(t, "rss1.rdf", FormatRSS1)
+	f := p.Feed
+	testutil.CheckEqual(t, []string{f.Title, f.Link, f.Desc}, []string{"RDF Site", "https://example.net/", "An RSS 1.0 feed"}, "feed")
+	testutil.CheckEqual(t, f.PubDate, time.Date(2020, 3, 1, 8, 0, 0, 0, time.UTC), "dc:date")
+	testutil.CheckEqual(t, len(f.Entries), 1, "entries")
+	e := f.Entries[0]
+	testutil.CheckEqual(t, []string{e.Title, e.Link, e.Desc, e.Author}, []string{"Item A", "https://example.net/a", "First item", "Bo"}, "entry")
+	testutil.CheckEqual(t, e.PubDate.Equal(time.Date(2020, 3, 1, 6, 0, 0, 0, time.UTC)), true, "entry dc:date")
+	testutil.CheckEqual(t, len(p.Issues), 0, "issues")
+}
+
+func TestParseAtom(t *testing.T) {
+	p := testParseFile(t, "atom.xml", FormatAtom)
+	f := p.Feed
+	testutil.CheckEqual(t, []string{f.ID, f.Title, f.Desc, f.Author}, []string{"urn:uuid:60a76c80-d399-11d9-b93C-0003939e0af6", "Atom Site", "Things", "Jane"}, "feed")
+	testutil.CheckEqual(t, []string{f.Link, f.SelfLink}, []string{"https://example.com/", "https://example.com/atom"}, "links")
+	testutil.CheckEqual(t, f.Rights, "&#169; <i>Jane</i>", "xhtml rights")
+	testutil.CheckEqual(t, f.PubDate, f.LastMod, "published defaults to updated")
+	testutil.CheckEqual(t, len(f.Entries), 2, "entries")
+
+	e := f.Entries[0]
+	testutil.CheckEqual(t, []string{e.ID, e.Link}, []string{"urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a", "https://example.com/1"}, "entry")
+	testutil.CheckEqual(t, e.Desc, "<p>Hello <em>world</em></p>", "xhtml summary")
+	testutil.CheckEqual(t, e.DescType, TextXHtml, "xhtml type")
+	testutil.CheckEqual(t, e.LastMod, time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC), "date only")
+	testutil.CheckEqual(t, e.Categories, []string{"tech"}, "categories")
+	testutil.CheckEqual(t, e.Enclosures, []Enclosure{{URL: "https://example.com/1.mp3", Type: "audio/mpeg", Length: 1337}}, "enclosures")
+
+	e = f.Entries[1]
+	testutil.CheckEqual(t, e.Title, "Post <b>2</b>", "xhtml title")
+	testutil.CheckEqual(t, []string{e.Desc, e.Content}, []string{"", "<p>Second</p>"}, "html content")
+	testutil.CheckEqual(t, e.ContentType, TextHtml, "html type")
+	testutil.CheckEqual(t, e.LastMod.IsZero(), true, "malformed updated")
+	testutil.CheckEqual(t, testIssues(p), []string{"entry 2: updated"}, "issues")
+}
+
+func TestParseJSONFeed(t *testing.T) {
+	p := testParseFile(t, "jsonfeed1.json", FormatJSONFeed)
+	f := p.Feed
+	testutil.CheckEqual(t, []string{f.Title, f.Link, f.SelfLink, f.Author}, []string{"JSON Site", "https://example.com/", "https://example.com/feed.json", "Jo"}, "feed")
+	testutil.CheckEqual(t, len(f.Entries), 2, "entries")
+	e := f.Entries[0]
+	testutil.CheckEqual(t, []string{e.ID, e.Link, e.Desc}, []string{"42", "https://example.com/42", "The answer"}, "entry")
+	testutil.CheckEqual(t, e.DescType, TextPlain, "text type")
+	testutil.CheckEqual(t, e.Categories, []string{"meta"}, "tags")
+	testutil.CheckEqual(t, e.Enclosures, []Enclosure{{URL: "https://example.com/42.mp3", Type: "audio/mpeg", Length: 99}}, "attachments")
+	e = f.Entries[1]
+	testutil.CheckEqual(t, []string{e.Link, e.Desc}, []string{"https://other.example/", "Elsewhere"}, "external url and summary")
+	testutil.CheckEqual(t, testIssues(p), []string{"entry 1: id", "entry 2: date_modified"}, "issues")
+}
+
+func TestParseEncodingAndErrors(t *testing.T) {
+	// undeclared windows-1252
+	p, err := Parse(strings.NewReader("<rss version=\"2.0\"><channel><title>Caf\xe9 \x93Bar\x94</title></channel></rss>"))
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, p.Feed.Title, "Café “Bar”", "windows-1252 title")
+	testutil.CheckEqual(t, testIssues(p), []string{"document"}, "issues")
+
+	_, err = Parse(strings.NewReader("<html><body>not a feed</body></html>"))
+	testutil.CheckEqual(t, err, UnknownFormatErr, "html")
+	_, err = Parse(strings.NewReader(""))
+	testutil.CheckEqual(t, err, UnknownFormatErr, "empty")
+	_, err = Parse(strings.NewReader("{\"version\": "))
+	testutil.CheckEqual(t, err != nil, true, "truncated json")
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	f0 := testFeed()
+	for _, x := range []struct {
+		format Format
+		write  func(*Feed, *bytes.Buffer) error
+	}{
+		{FormatAtom, func(f *Feed, b *bytes.Buffer) error { return f.ToAtom(b, "") }},
+		{FormatRSS2, func(f *Feed, b *bytes.Buffer) error { return f.ToRSS(b, "") }},
+		{FormatJSONFeed, func(f *Feed, b *bytes.Buffer) error { return f.ToJSONFeed(b, "") }},
+	} {
+		var buf bytes.Buffer
+		testutil.CheckErr(t, x.write(f0, &buf))
+		p, err := Parse(&buf)
+		testutil.CheckErr(t, err)
+		desc := x.format.String()
+		testutil.CheckEqual(t, p.Format, x.format, desc+": format")
+		testutil.CheckEqual(t, len(p.Issues), 0, desc+": issues")
+		f := p.Feed
+		testutil.CheckEqual(t, []string{f.Title, f.Link, f.SelfLink, f.Desc}, []string{f0.Title, f0.Link, f0.SelfLink, f0.Desc}, desc+": feed")
+		testutil.CheckEqual(t, len(f.Entries), len(f0.Entries), desc+": entries")
+		for i, e := range f.Entries {
+			e0 := f0.Entries[i]
+			desc0 := e0.Desc
+			if x.format == FormatRSS2 && !e0.DescType.isHTML() {
+				desc0 = html.EscapeString(desc0) // rss descriptions are html
+			}
+			testutil.CheckEqual(t, []string{e.Title, e.Link, entryID(e), e.Desc, e.Content}, []string{e0.Title, e0.Link, entryID(e0), desc0, e0.Content}, desc+": entry")
+			testutil.CheckEqual(t, e.Categories, e0.Categories, desc+": categories")
+			testutil.CheckEqual(t, e.PubDate.Equal(e0.PubDate), true, desc+": pubdate")
+		}
+	}
+}
+
+func TestParseAtomRoundTrip(t *testing.T) {
+	f0 := testFeed()
+	var buf bytes.Buffer
+	testutil.CheckErr(t, f0.ToAtom(&buf, "  "))
+	p, err := Parse(&buf)
+	testutil.CheckErr(t, err)
+	f := p.Feed
+	testutil.CheckEqual(t, []string{f.ID, f.Rights}, []string{f0.ID, f0.Rights}, "feed")
+	testutil.CheckEqual(t, f.Contributors, f0.Contributors, "feed contributors")
+	testutil.CheckEqual(t, f.Links, f0.Links, "feed links")
+	for i, e := range f.Entries {
+		e0 := f0.Entries[i]
+		testutil.CheckEqual(t, []textType{e.DescType, e.ContentType}, []textType{e0.DescType, e0.ContentType}, "types")
+		testutil.CheckEqual(t, e.Rights, e0.Rights, "rights")
+		testutil.CheckEqual(t, e.Contributors, e0.Contributors, "contributors")
+		testutil.CheckEqual(t, e.Links, e0.Links, "links")
+		testutil.CheckEqual(t, e.Enclosures, e0.Enclosures, "enclosures")
+	}
+}
@@ -0,0 +1,106 @@
+package feed
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// itemJSONIn supports the author of JSON Feed 1.0, and the authors of 1.1.
+type itemJSONIn struct {
+	ID            json.RawMessage  `json:"id"` // a string, but numbers are common
+	URL           string           `json:"url"`
+	ExternalURL   string           `json:"external_url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	ContentText   string           `json:"content_text"`
+	Summary       string           `json:"summary"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Author        *authorJSON      `json:"author"`
+	Authors       []authorJSON     `json:"authors"`
+	Tags          []string         `json:"tags"`
+	Attachments   []attachmentJSON `json:"attachments"`
+}
+
+type feedJSONIn struct {
+	Version     string       `json:"version"`
+	Title       string       `json:"title"`
+	HomePageURL string       `json:"home_page_url"`
+	FeedURL     string       `json:"feed_url"`
+	Description string       `json:"description"`
+	Author      *authorJSON  `json:"author"`
+	Authors     []authorJSON `json:"authors"`
+	Items       []itemJSONIn `json:"items"`
+}
+
+func jsonAuthor(a *authorJSON, as []authorJSON) string {
+	for _, x := range as {
+		if x.Name != "" {
+			return x.Name
+		}
+	}
+	if a != nil {
+		return a.Name
+	}
+	return ""
+}
+
+func (p *Parsed) parseJSON(b []byte) (err error) {
+	var x feedJSONIn
+	if err = json.Unmarshal(b, &x); err != nil {
+		return
+	}
+	if !strings.HasPrefix(x.Version, "https://jsonfeed.org/version/") {
+		p.issue("feed: version", "unknown JSON Feed version: "+x.Version)
+	}
+	f := &Feed{
+		Title:    x.Title,
+		Link:     x.HomePageURL,
+		SelfLink: x.FeedURL,
+		Desc:     x.Description,
+		Author:   jsonAuthor(x.Author, x.Authors),
+	}
+	for i := range x.Items {
+		f.Entries = append(f.Entries, x.Items[i].entry(p, i))
+	}
+	p.Feed = f
+	return
+}
+
+func (x *itemJSONIn) entry(p *Parsed, i int) *Entry {
+	e := &Entry{
+		Link:       firstNonEmpty(x.URL, x.ExternalURL),
+		Title:      x.Title,
+		PubDate:    p.date(entryWhere(i, "date_published"), x.DatePublished),
+		LastMod:    p.date(entryWhere(i, "date_modified"), x.DateModified),
+		Author:     jsonAuthor(x.Author, x.Authors),
+		Categories: x.Tags,
+	}
+	if len(x.ID) > 0 {
+		var s string
+		if json.Unmarshal(x.ID, &s) != nil {
+			p.issue(entryWhere(i, "id"), "not a string: "+string(x.ID))
+			s = string(x.ID)
+		}
+		e.ID = s
+	}
+	var content string
+	var typ textType
+	switch {
+	case x.ContentHTML != "":
+		content, typ = x.ContentHTML, TextHtml
+	case x.ContentText != "":
+		content, typ = x.ContentText, TextPlain
+	}
+	// with a summary, the content is the full Content (as written by ToJSONFeed)
+	if x.Summary != "" {
+		e.Desc, e.DescType = x.Summary, TextPlain
+		e.Content, e.ContentType = content, typ
+	} else {
+		e.Desc, e.DescType = content, typ
+	}
+	for _, a := range x.Attachments {
+		e.Enclosures = append(e.Enclosures, Enclosure{URL: a.URL, Type: a.MimeType, Length: a.SizeInBytes})
+	}
+	return e
+}
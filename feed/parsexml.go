@@ -0,0 +1,320 @@
+package feed
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// atom
+
+type atomTextIn struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+type atomPersonIn struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email"`
+	URI   string `xml:"uri"`
+}
+
+type atomLinkIn struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Title  string `xml:"title,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type atomCategoryIn struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntryIn struct {
+	ID           string           `xml:"id"`
+	Title        atomTextIn       `xml:"title"`
+	Links        []atomLinkIn     `xml:"link"`
+	Published    string           `xml:"published"`
+	Updated      string           `xml:"updated"`
+	Authors      []atomPersonIn   `xml:"author"`
+	Contributors []atomPersonIn   `xml:"contributor"`
+	Categories   []atomCategoryIn `xml:"category"`
+	Summary      *atomTextIn      `xml:"summary"`
+	Content      *atomTextIn      `xml:"content"`
+	Rights       atomTextIn       `xml:"rights"`
+}
+
+type atomFeedIn struct {
+	ID           string         `xml:"id"`
+	Title        atomTextIn     `xml:"title"`
+	Subtitle     atomTextIn     `xml:"subtitle"`
+	Links        []atomLinkIn   `xml:"link"`
+	Published    string         `xml:"published"`
+	Updated      string         `xml:"updated"`
+	Authors      []atomPersonIn `xml:"author"`
+	Contributors []atomPersonIn `xml:"contributor"`
+	Rights       atomTextIn     `xml:"rights"`
+	Archive      *struct{}      `xml:"http://purl.org/syndication/history/1.0 archive"`
+	Complete     *struct{}      `xml:"http://purl.org/syndication/history/1.0 complete"`
+	Entries      []atomEntryIn  `xml:"entry"`
+}
+
+func entryWhere(i int, field string) string {
+	return "entry " + strconv.Itoa(i+1) + ": " + field
+}
+
+// value returns the text, and its type. The xhtml div wrapper is removed.
+func (x *atomTextIn) value() (s string, typ textType) {
+	if typ = textTypeOf(x.Type); typ == TextXHtml {
+		return xhtmlDiv(x.Inner), typ
+	}
+	return strings.TrimSpace(x.Text), typ
+}
+
+func xhtmlDiv(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "<div") && strings.HasSuffix(s, "</div>") {
+		if i := strings.IndexByte(s, '>'); i >= 0 {
+			s = strings.TrimSpace(s[i+1 : len(s)-len("</div>")])
+		}
+	}
+	return s
+}
+
+func atomAuthor(ps []atomPersonIn) string {
+	for _, x := range ps {
+		if x.Name = strings.TrimSpace(x.Name); x.Name != "" {
+			return x.Name
+		}
+	}
+	return ""
+}
+
+func atomPersons(ps []atomPersonIn) (xs []Person) {
+	for _, x := range ps {
+		xs = append(xs, Person{Name: strings.TrimSpace(x.Name), Email: strings.TrimSpace(x.Email), URI: strings.TrimSpace(x.URI)})
+	}
+	return
+}
+
+func (x *atomLinkIn) link() Link {
+	return Link{Href: x.Href, Rel: x.Rel, Type: x.Type, Title: x.Title}
+}
+
+func (x *atomFeedIn) feed(p *Parsed) *Feed {
+	f := &Feed{
+		ID:      strings.TrimSpace(x.ID),
+		PubDate: p.date("feed: published", x.Published),
+		LastMod: p.date("feed: updated", x.Updated),
+		Author:  atomAuthor(x.Authors),
+	}
+	f.Title, _ = x.Title.value()
+	f.Desc, _ = x.Subtitle.value()
+	f.Rights, _ = x.Rights.value()
+	f.Contributors = atomPersons(x.Contributors)
+	paging := &Paging{Archive: x.Archive != nil, Complete: x.Complete != nil}
+	hasPaging := paging.Archive || paging.Complete
+	for i := range x.Links {
+		switch l := &x.Links[i]; {
+		case (l.Rel == "" || l.Rel == "alternate") && f.Link == "":
+			f.Link = l.Href
+		case l.Rel == "self" && f.SelfLink == "":
+			f.SelfLink = l.Href
+		case paging.set(l.Rel, l.Href):
+			hasPaging = true
+		default:
+			f.Links = append(f.Links, l.link())
+		}
+	}
+	if hasPaging {
+		f.Paging = paging
+	}
+	for i := range x.Entries {
+		f.Entries = append(f.Entries, x.Entries[i].entry(p, i))
+	}
+	return f
+}
+
+func (x *atomEntryIn) entry(p *Parsed, i int) *Entry {
+	e := &Entry{
+		ID:      strings.TrimSpace(x.ID),
+		PubDate: p.date(entryWhere(i, "published"), x.Published),
+		LastMod: p.date(entryWhere(i, "updated"), x.Updated),
+		Author:  atomAuthor(x.Authors),
+	}
+	e.Title, _ = x.Title.value()
+	e.Rights, _ = x.Rights.value()
+	e.Contributors = atomPersons(x.Contributors)
+	for j := range x.Links {
+		switch l := &x.Links[j]; {
+		case (l.Rel == "" || l.Rel == "alternate") && e.Link == "":
+			e.Link = l.Href
+		case l.Rel == "enclosure":
+			e.Enclosures = append(e.Enclosures, Enclosure{URL: l.Href, Type: l.Type, Length: p.length(i, l.Length)})
+		default:
+			e.Links = append(e.Links, l.link())
+		}
+	}
+	for _, c := range x.Categories {
+		e.Categories = append(e.Categories, c.Term)
+	}
+	if x.Summary != nil {
+		e.Desc, e.DescType = x.Summary.value()
+	}
+	if x.Content != nil {
+		e.Content, e.ContentType = x.Content.value()
+	}
+	return e
+}
+
+func (p *Parsed) length(i int, s string) (n int64) {
+	if s = strings.TrimSpace(s); s == "" {
+		return
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		p.issue(entryWhere(i, "enclosure"), "malformed length: "+s)
+	}
+	return
+}
+
+// rss (2.0 and 1.0)
+
+type rssLinkIn struct {
+	XMLName xml.Name
+	Href    string `xml:"href,attr"`
+	Rel     string `xml:"rel,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type rssGuidIn struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Text        string `xml:",chardata"`
+}
+
+type rssEnclosureIn struct {
+	URL    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssItemIn struct {
+	Title       string           `xml:"title"`
+	Links       []rssLinkIn      `xml:"link"`
+	Description string           `xml:"description"`
+	Content     string           `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Author      string           `xml:"author"`
+	Creator     string           `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories  []string         `xml:"category"`
+	Subjects    []string         `xml:"http://purl.org/dc/elements/1.1/ subject"`
+	Enclosures  []rssEnclosureIn `xml:"enclosure"`
+	Guid        *rssGuidIn       `xml:"guid"`
+	PubDate     string           `xml:"pubDate"`
+	Date        string           `xml:"http://purl.org/dc/elements/1.1/ date"`
+}
+
+type rssChannelIn struct {
+	Title          string      `xml:"title"`
+	Links          []rssLinkIn `xml:"link"`
+	Description    string      `xml:"description"`
+	Copyright      string      `xml:"copyright"`
+	ManagingEditor string      `xml:"managingEditor"`
+	Creator        string      `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	PubDate        string      `xml:"pubDate"`
+	Date           string      `xml:"http://purl.org/dc/elements/1.1/ date"`
+	LastBuildDate  string      `xml:"lastBuildDate"`
+	Items          []rssItemIn `xml:"item"`
+}
+
+type rss2In struct {
+	Channel rssChannelIn `xml:"channel"`
+}
+
+// rss1In is an RDF document, where the items are siblings of the channel.
+type rss1In struct {
+	Channel rssChannelIn `xml:"channel"`
+	Items   []rssItemIn  `xml:"item"`
+}
+
+func (x *rss2In) feed(p *Parsed) *Feed {
+	return x.Channel.feed(p, x.Channel.Items)
+}
+
+func (x *rss1In) feed(p *Parsed) *Feed {
+	return x.Channel.feed(p, append(x.Channel.Items, x.Items...))
+}
+
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if s = strings.TrimSpace(s); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// rssLinks returns the link (text of a non-atom link element) and the atom self link.
+func rssLinks(ls []rssLinkIn) (link, self string) {
+	for _, l := range ls {
+		if l.XMLName.Space == nsAtom {
+			if l.Rel == "self" {
+				self = l.Href
+			}
+		} else if link == "" {
+			link = strings.TrimSpace(l.Text)
+		}
+	}
+	return
+}
+
+func (x *rssChannelIn) feed(p *Parsed, items []rssItemIn) *Feed {
+	f := &Feed{
+		Title:   strings.TrimSpace(x.Title),
+		Desc:    strings.TrimSpace(x.Description),
+		Rights:  strings.TrimSpace(x.Copyright),
+		Author:  firstNonEmpty(x.Creator, x.ManagingEditor),
+		PubDate: p.date("feed: pubDate", firstNonEmpty(x.PubDate, x.Date)),
+		LastMod: p.date("feed: lastBuildDate", x.LastBuildDate),
+	}
+	f.Link, f.SelfLink = rssLinks(x.Links)
+	for i := range items {
+		f.Entries = append(f.Entries, items[i].entry(p, i))
+	}
+	return f
+}
+
+func (x *rssItemIn) entry(p *Parsed, i int) *Entry {
+	e := &Entry{
+		Title:    strings.TrimSpace(x.Title),
+		Desc:     strings.TrimSpace(x.Description),
+		DescType: TextHtml,
+		Author:   firstNonEmpty(x.Creator, x.Author),
+		PubDate:  p.date(entryWhere(i, "pubDate"), firstNonEmpty(x.PubDate, x.Date)),
+	}
+	if e.Content = strings.TrimSpace(x.Content); e.Content != "" {
+		e.ContentType = TextHtml
+	}
+	e.Link, _ = rssLinks(x.Links)
+	if x.Guid != nil {
+		// a permalink guid is the link; it is only kept as ID if it differs
+		guid := strings.TrimSpace(x.Guid.Text)
+		if strings.EqualFold(strings.TrimSpace(x.Guid.IsPermaLink), "false") {
+			e.ID = guid
+		} else if e.Link == "" {
+			e.Link = guid
+		} else if guid != e.Link {
+			e.ID = guid
+		}
+	}
+	for _, c := range append(x.Categories, x.Subjects...) {
+		if c = strings.TrimSpace(c); c != "" {
+			e.Categories = append(e.Categories, c)
+		}
+	}
+	for _, c := range x.Enclosures {
+		e.Enclosures = append(e.Enclosures, Enclosure{URL: c.URL, Type: c.Type, Length: p.length(i, c.Length)})
+	}
+	return e
+}
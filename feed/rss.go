@@ -0,0 +1,144 @@
+package feed
+
+import (
+	"encoding/xml"
+	"html"
+	"io"
+	"strconv"
+	"time"
+)
+
+// NOTES:
+// RSS 2.0 requires title, link and description on the channel, and title or description on an item.
+// An item may have only one enclosure, so only the first one is written.
+// An item author must be an email address, so the dc:creator element is used instead.
+// The full content of an item is in content:encoded (its description is the summary).
+// The guid is the same ID as in the other formats (see entryID), so it is not a permalink.
+// Dates are in RFC 822 format (with 4-digit years).
+
+type guidRSS struct {
+	XMLName     xml.Name `xml:"guid"`
+	S           string   `xml:",chardata"`
+	IsPermaLink bool     `xml:"isPermaLink,attr"`
+}
+
+type enclosureRSS struct {
+	XMLName xml.Name `xml:"enclosure"`
+	URL     string   `xml:"url,attr"`
+	Length  string   `xml:"length,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+type atomLinkRSS struct {
+	XMLName xml.Name `xml:"atom:link"`
+	Href    string   `xml:"href,attr"`
+	Rel     string   `xml:"rel,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+type itemRSS struct {
+	XMLName     xml.Name `xml:"item"`
+	Title       string   `xml:"title,omitempty"`
+	Link        string   `xml:"link,omitempty"`
+	Description string   `xml:"description,omitempty"`
+	Content     string   `xml:"content:encoded,omitempty"`
+	Creator     string   `xml:"dc:creator,omitempty"`
+	Categories  []string `xml:"category"`
+	Enclosure   *enclosureRSS
+	Guid        guidRSS
+	PubDate     string `xml:"pubDate,omitempty"`
+}
+
+type channelRSS struct {
+	XMLName       xml.Name `xml:"channel"`
+	Title         string   `xml:"title"`
+	Link          string   `xml:"link"`
+	Description   string   `xml:"description"`
+	SelfLink      *atomLinkRSS
+	Copyright     string `xml:"copyright,omitempty"`
+	Creator       string `xml:"dc:creator,omitempty"`
+	PubDate       string `xml:"pubDate,omitempty"`
+	LastBuildDate string `xml:"lastBuildDate,omitempty"`
+	Items         []*itemRSS
+}
+
+type rss struct {
+	XMLName   xml.Name `xml:"rss"`
+	Version   string   `xml:"version,attr"`
+	NsAtom    string   `xml:"xmlns:atom,attr"`
+	NsDC      string   `xml:"xmlns:dc,attr"`
+	NsContent string   `xml:"xmlns:content,attr"`
+	Channel   channelRSS
+}
+
+func rssDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC1123Z)
+}
+
+func rssHTML(s string, typ textType) string {
+	if !typ.isHTML() {
+		return html.EscapeString(s)
+	}
+	return s
+}
+
+func toRSSItem(e *Entry) *itemRSS {
+	xe := itemRSS{
+		Title:      e.Title,
+		Link:       e.Link,
+		Creator:    e.Author,
+		Categories: e.Categories,
+		PubDate:    rssDate(e.PubDate),
+	}
+	xe.Guid = guidRSS{S: entryID(e)}
+	// description and content are always html
+	xe.Description = rssHTML(e.Desc, e.DescType)
+	xe.Content = rssHTML(e.Content, e.ContentType)
+	if len(e.Enclosures) > 0 {
+		x := e.Enclosures[0]
+		xe.Enclosure = &enclosureRSS{URL: x.URL, Length: strconv.FormatInt(x.Length, 10), Type: x.Type}
+	}
+	return &xe
+}
+
+func toRSS(f *Feed) *rss {
+	xf := rss{
+		Version:   "2.0",
+		NsAtom:    "http://www.w3.org/2005/Atom",
+		NsDC:      "http://purl.org/dc/elements/1.1/",
+		NsContent: "http://purl.org/rss/1.0/modules/content/",
+		Channel: channelRSS{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Desc,
+			Copyright:     f.Rights,
+			Creator:       f.Author,
+			PubDate:       rssDate(f.PubDate),
+			LastBuildDate: rssDate(f.LastMod),
+		},
+	}
+	if xf.Channel.Description == "" {
+		xf.Channel.Description = f.Title
+	}
+	if f.SelfLink != "" {
+		xf.Channel.SelfLink = &atomLinkRSS{Href: f.SelfLink, Rel: "self", Type: "application/rss+xml"}
+	}
+	for _, e := range f.Entries {
+		xf.Channel.Items = append(xf.Channel.Items, toRSSItem(e))
+	}
+	return &xf
+}
+
+// ToRSS writes the feed in RSS 2.0 format.
+func (f *Feed) ToRSS(w io.Writer, indent string) (err error) {
+	xf := toRSS(f)
+	if _, err = io.WriteString(w, xml.Header); err != nil {
+		return
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", indent)
+	return enc.Encode(xf)
+}
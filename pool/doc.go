@@ -1,4 +1,18 @@
 /*
 Package pool manages a pool of resources.
+
+Pool[V] is a typed pool, whose objects are managed by separate hooks
+(create, validate, reset, destroy).
+
+T is the original untyped pool, where a single Fn callback handles every Action.
+It is a thin layer over a Pool[interface{}].
+
+T.Get(t) no longer waits up to t for an object to be returned before creating one:
+it creates one as soon as none is idle. t only bounds the wait when Options.MaxActive
+is reached, so with MaxActive set, Get(0) fails at once (with context.DeadlineExceeded)
+if MaxActive objects are borrowed. With MaxActive 0 (the default), Get never fails for lack of objects.
+
+Keyed[K, V] partitions objects by key (e.g. connections per backend host),
+lazily creating a Pool[V] per key, with limits shared across all keys.
 */
 package pool
@@ -0,0 +1,501 @@
+package pool
+
+import (
+	"container/list"
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ugorji/go-common/errorutil"
+)
+
+// Hooks are the callbacks which manage the lifecycle of the objects in a Pool.
+type Hooks[V any] struct {
+	// Create creates a new object. It is required.
+	Create func(ctx context.Context) (V, error)
+	// Validate returns false if an idle object is no longer usable
+	// (checked on borrow if Options.ValidateOnBorrow is set, and during health checks).
+	Validate func(v V) bool
+	// Reset prepares a returned object for reuse. If it returns an error, the object is destroyed.
+	Reset func(v V) error
+	// Destroy releases the resources of an object which leaves the pool.
+	Destroy func(v V) error
+}
+
+// Pool is a typed pool of objects, whose lifecycle is managed by Hooks.
+//
+// It enforces the limits and health configured by the Options:
+// see Options for the details.
+type Pool[V any] struct {
+	mu       sync.Mutex
+	idle     []idleItem[V] // oldest first
+	capacity int
+	active   int
+	waiters  list.List // of *waiter[V], in FIFO order
+	stats    Stats
+	hooks    Hooks[V]
+	opts     Options
+	born     map[interface{}]time.Time // creation time of objects, if MaxLifetime is set
+	closeC   chan struct{}
+	closed   bool
+}
+
+// idleItem is an object in the pool.
+type idleItem[V any] struct {
+	v     V
+	since time.Time // when it was returned to the pool
+}
+
+// waiter is a call to Get waiting for an object.
+//
+// It receives either an idle object, or (if nil) the permission to create one.
+type waiter[V any] struct {
+	c      chan *idleItem[V]
+	handed bool // set (under the lock) when removed from the queue and sent to
+}
+
+type replenishKey struct{}
+
+// replenishContext is passed to the Create hook when the health check replenishes MinIdle.
+// It tells a Keyed pool not to wait for its total limit.
+var replenishContext = context.WithValue(context.Background(), replenishKey{}, true)
+
+// NewPool creates a new Pool, with load objects created upfront.
+// capacity is the maximum number of idle objects.
+//
+// If opts.HealthCheckInterval is set, Close must be called to stop the background health check.
+func NewPool[V any](hooks Hooks[V], load, capacity int, opts Options) (p *Pool[V], err error) {
+	p = newPool(hooks, capacity, opts)
+	if err = p.preload(p.clampLoad(load)); err != nil {
+		return nil, err
+	}
+	p.startHealthCheck()
+	return p, nil
+}
+
+func newPool[V any](hooks Hooks[V], capacity int, opts Options) (p *Pool[V]) {
+	if capacity < 1 {
+		capacity = 1
+	}
+	p = &Pool[V]{
+		hooks:    hooks,
+		capacity: capacity,
+		opts:     opts,
+	}
+	if p.opts.MinIdle > capacity {
+		// the health check could never keep more idle objects than the capacity
+		p.opts.MinIdle = capacity
+	}
+	if opts.MaxLifetime > 0 {
+		p.born = make(map[interface{}]time.Time)
+	}
+	return
+}
+
+// clampLoad returns how many objects to preload, given the limits.
+func (p *Pool[V]) clampLoad(load int) int {
+	if load < p.opts.MinIdle {
+		load = p.opts.MinIdle
+	}
+	if load < 0 {
+		load = 0
+	} else if load > p.capacity {
+		load = p.capacity
+	}
+	if p.opts.MaxActive > 0 && load > p.opts.MaxActive {
+		load = p.opts.MaxActive
+	}
+	return load
+}
+
+func (p *Pool[V]) startHealthCheck() {
+	if p.opts.HealthCheckInterval > 0 {
+		p.closeC = make(chan struct{})
+		go p.healthLoop()
+	}
+}
+
+func (p *Pool[V]) preload(load int) (err error) {
+	vs := make([]V, load)
+	for i := 0; i < load; i++ {
+		if vs[i], _, err = p.get(context.Background()); err != nil {
+			return
+		}
+	}
+	for i := 0; i < load; i++ {
+		p.put(vs[i])
+	}
+	return
+}
+
+func (p *Pool[V]) now() time.Time {
+	if p.opts.Now != nil {
+		return p.opts.Now()
+	}
+	return time.Now()
+}
+
+// Get returns an idle object from the pool, or creates one if none is available.
+//
+// If Options.MaxActive objects are already borrowed, it waits until one is returned
+// (callers are served in FIFO order), or the context is done (returning its error).
+//
+// Idle objects which expired or fail validation are destroyed, and skipped.
+func (p *Pool[V]) Get(ctx context.Context) (v V, err error) {
+	v, _, err = p.get(ctx)
+	return
+}
+
+func (p *Pool[V]) get(ctx context.Context) (v V, created bool, err error) {
+	for {
+		p.mu.Lock()
+		var it *idleItem[V]
+		if len(p.idle) > 0 && p.waiters.Len() == 0 {
+			it = &idleItem[V]{}
+			*it, p.idle = p.idle[0], p.idle[1:]
+			p.active++
+		} else if p.opts.MaxActive <= 0 || p.active < p.opts.MaxActive {
+			p.active++
+		} else if it, err = p.wait(ctx); err != nil {
+			return
+		}
+		p.mu.Unlock()
+		if it == nil {
+			v, err = p.create(ctx)
+			return v, true, err
+		}
+		if p.usable(*it, p.opts.ValidateOnBorrow) {
+			return it.v, false, nil
+		}
+		p.release()
+	}
+}
+
+// wait queues the caller, and waits for an object (or the permission to create one).
+// It is called with the lock held, and returns with it held (unless it returns an error, after unlocking).
+func (p *Pool[V]) wait(ctx context.Context) (it *idleItem[V], err error) {
+	w := &waiter[V]{c: make(chan *idleItem[V], 1)}
+	e := p.waiters.PushBack(w)
+	p.stats.Waits++
+	p.mu.Unlock()
+	start := time.Now()
+	select {
+	case it = <-w.c:
+		p.mu.Lock()
+		p.stats.WaitTime += time.Since(start)
+	case <-ctx.Done():
+		p.mu.Lock()
+		p.stats.WaitTime += time.Since(start)
+		p.stats.Timeouts++
+		err = ctx.Err()
+		var it2 *idleItem[V]
+		if !w.handed {
+			p.waiters.Remove(e)
+		} else if it2 = <-w.c; it2 == nil {
+			// handed the permission to create just before giving up: pass it on
+			p.releaseLocked()
+		} else if p.putIdleLocked(*it2, true) {
+			// handed an object just before giving up: pass it on
+			it2 = nil
+		}
+		p.mu.Unlock()
+		if it2 != nil {
+			p.destroy(it2.v)
+		}
+	}
+	return
+}
+
+// create creates a new object, in a slot already counted as active.
+func (p *Pool[V]) create(ctx context.Context) (v V, err error) {
+	if v, err = p.hooks.Create(ctx); err != nil {
+		p.release()
+		return
+	}
+	p.mu.Lock()
+	p.stats.Creations++
+	p.mu.Unlock()
+	p.track(v)
+	return
+}
+
+// release gives up an active slot (e.g. the object was destroyed),
+// passing the permission to create an object to the first waiter.
+func (p *Pool[V]) release() {
+	p.mu.Lock()
+	p.releaseLocked()
+	p.mu.Unlock()
+}
+
+func (p *Pool[V]) releaseLocked() {
+	p.releaseActiveLocked()
+	if e := p.waiters.Front(); e != nil {
+		p.active++
+		p.handoff(e, nil)
+	}
+}
+
+// putIdleLocked hands the object to the first waiter, or adds it to the idle objects.
+// It returns false if the pool is full (so the object must be destroyed).
+// fromActive is true if the object was borrowed (and so counted as active).
+func (p *Pool[V]) putIdleLocked(it idleItem[V], fromActive bool) bool {
+	if e := p.waiters.Front(); e != nil && (fromActive || p.opts.MaxActive <= 0 || p.active < p.opts.MaxActive) {
+		if !fromActive {
+			p.active++
+		}
+		p.handoff(e, &it)
+		return true
+	}
+	if fromActive {
+		p.releaseActiveLocked()
+	}
+	if len(p.idle) >= p.capacity {
+		return false
+	}
+	p.idle = append(p.idle, it)
+	return true
+}
+
+// releaseActiveLocked decrements the active count. It never goes negative,
+// e.g. when an object which was not borrowed is put in the pool.
+func (p *Pool[V]) releaseActiveLocked() {
+	if p.active > 0 {
+		p.active--
+	}
+}
+
+// reinsertLocked puts back an idle object which was taken out to be checked, at index i
+// (so the idle objects stay oldest first), unless a waiter can take it.
+// It returns false if the pool is full.
+func (p *Pool[V]) reinsertLocked(it idleItem[V], i int) bool {
+	if p.waiters.Len() > 0 || len(p.idle) >= p.capacity {
+		return p.putIdleLocked(it, false)
+	}
+	if i > len(p.idle) {
+		i = len(p.idle)
+	}
+	p.idle = append(p.idle, idleItem[V]{})
+	copy(p.idle[i+1:], p.idle[i:])
+	p.idle[i] = it
+	return true
+}
+
+func (p *Pool[V]) handoff(e *list.Element, it *idleItem[V]) {
+	w := p.waiters.Remove(e).(*waiter[V])
+	w.handed = true
+	w.c <- it
+}
+
+// usable returns true if the idle object has not expired (and is valid, if validate is true).
+// If not usable, the object is destroyed.
+func (p *Pool[V]) usable(it idleItem[V], validate bool) bool {
+	now := p.now()
+	if (p.opts.MaxIdleTime > 0 && now.Sub(it.since) > p.opts.MaxIdleTime) || p.expired(it.v, now) ||
+		(validate && p.hooks.Validate != nil && !p.hooks.Validate(it.v)) {
+		p.destroy(it.v)
+		return false
+	}
+	return true
+}
+
+func trackable(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	return t != nil && t.Comparable()
+}
+
+// track records the creation time of a new object, to enforce MaxLifetime.
+func (p *Pool[V]) track(v V) {
+	if p.born == nil || !trackable(v) {
+		return
+	}
+	p.mu.Lock()
+	p.born[v] = p.now()
+	p.mu.Unlock()
+}
+
+// untrack forgets the creation time of an object which leaves the pool.
+func (p *Pool[V]) untrack(v V) {
+	if p.born == nil || !trackable(v) {
+		return
+	}
+	p.mu.Lock()
+	delete(p.born, v)
+	p.mu.Unlock()
+}
+
+func (p *Pool[V]) expired(v V, now time.Time) (b bool) {
+	if p.born == nil || !trackable(v) {
+		return
+	}
+	p.mu.Lock()
+	t, ok := p.born[v]
+	p.mu.Unlock()
+	return ok && now.Sub(t) > p.opts.MaxLifetime
+}
+
+func (p *Pool[V]) destroy(v V) (err error) {
+	p.untrack(v)
+	p.mu.Lock()
+	p.stats.Disposals++
+	p.mu.Unlock()
+	if p.hooks.Destroy != nil {
+		err = p.hooks.Destroy(v)
+	}
+	return
+}
+
+// oldestIdle returns when the oldest idle object was returned to the pool (ok is false if there is none).
+func (p *Pool[V]) oldestIdle() (since time.Time, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) == 0 {
+		return
+	}
+	return p.idle[0].since, true
+}
+
+// takeIdle removes the oldest idle object from the pool (ok is false if there is none).
+func (p *Pool[V]) takeIdle() (v V, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) == 0 {
+		return
+	}
+	v = p.idle[0].v
+	p.idle = p.idle[1:]
+	return v, true
+}
+
+// Len returns the number of idle objects.
+func (p *Pool[V]) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// Stats returns the current state and counters of the pool.
+func (p *Pool[V]) Stats() (s Stats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s = p.stats
+	s.Active, s.Idle = p.active, len(p.idle)
+	return
+}
+
+// Put returns a borrowed object to the pool, after resetting it.
+//
+// It is handed to the first waiting Get (if any), else kept idle.
+// If the pool is full, the object expired or Reset fails, it is destroyed.
+func (p *Pool[V]) Put(v V) (err error) {
+	if p.hooks.Reset != nil {
+		if err = p.hooks.Reset(v); err != nil {
+			p.release()
+			p.destroy(v)
+			return
+		}
+	}
+	return p.put(v)
+}
+
+func (p *Pool[V]) put(v V) (err error) {
+	if p.expired(v, p.now()) {
+		p.release()
+		return p.destroy(v)
+	}
+	p.mu.Lock()
+	ok := p.putIdleLocked(idleItem[V]{v, p.now()}, true)
+	p.mu.Unlock()
+	if !ok {
+		err = p.destroy(v)
+	}
+	return
+}
+
+// Drain destroys all the idle objects.
+func (p *Pool[V]) Drain() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	var merr errorutil.Multi
+	for _, it := range idle {
+		if err := p.destroy(it.v); err != nil {
+			merr = append(merr, err)
+		}
+	}
+	return merr.NonNilError()
+}
+
+// Close stops the background health check (if any).
+//
+// The pool can still be used afterwards. Call Drain to destroy the idle objects.
+func (p *Pool[V]) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closeC != nil && !p.closed {
+		p.closed = true
+		close(p.closeC)
+	}
+	return nil
+}
+
+func (p *Pool[V]) healthLoop() {
+	tick := time.NewTicker(p.opts.HealthCheckInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			p.HealthCheck()
+		case <-p.closeC:
+			return
+		}
+	}
+}
+
+// HealthCheck checks the idle objects: expired ones are destroyed,
+// the rest are validated, and the pool is replenished up to Options.MinIdle.
+//
+// It is called periodically if Options.HealthCheckInterval is set.
+func (p *Pool[V]) HealthCheck() (err error) {
+	var merr errorutil.Multi
+	// check the idle objects one at a time, so the others can still be borrowed meanwhile
+	for i := 0; ; {
+		p.mu.Lock()
+		if i >= len(p.idle) {
+			p.mu.Unlock()
+			break
+		}
+		it := p.idle[i]
+		p.idle = append(p.idle[:i], p.idle[i+1:]...)
+		p.mu.Unlock()
+		if !p.usable(it, true) {
+			continue
+		}
+		p.mu.Lock()
+		ok := p.reinsertLocked(it, i)
+		p.mu.Unlock()
+		if !ok {
+			merr = append(merr, p.destroy(it.v))
+		}
+		i++
+	}
+	for p.Len() < p.opts.MinIdle {
+		v, err := p.hooks.Create(replenishContext)
+		if err != nil {
+			merr = append(merr, err)
+			break
+		}
+		p.track(v)
+		p.mu.Lock()
+		p.stats.Creations++
+		ok := p.putIdleLocked(idleItem[V]{v, p.now()}, false)
+		p.mu.Unlock()
+		if !ok {
+			// the pool filled up meanwhile
+			merr = append(merr, p.destroy(v))
+			break
+		}
+	}
+	return merr.NonNilError()
+}
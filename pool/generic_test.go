@@ -0,0 +1,60 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func TestGenericPool(t *testing.T) {
+	ctx := context.Background()
+	var created, destroyed []*testConn
+	var resets int
+	p, err := NewPool(Hooks[*testConn]{
+		Create: func(ctx context.Context) (*testConn, error) {
+			c := &testConn{id: len(created)}
+			created = append(created, c)
+			return c, nil
+		},
+		Validate: func(c *testConn) bool { return !c.broken },
+		Reset: func(c *testConn) error {
+			resets++
+			if c.id == 1 {
+				return errors.New("cannot reset")
+			}
+			return nil
+		},
+		Destroy: func(c *testConn) error {
+			destroyed = append(destroyed, c)
+			return nil
+		},
+	}, 1, 2, Options{ValidateOnBorrow: true})
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, len(created), 1, "preloaded")
+
+	c0, err := p.Get(ctx)
+	testutil.CheckErr(t, err)
+	c1, err := p.Get(ctx)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, []int{c0.id, c1.id}, []int{0, 1}, "ids")
+
+	// a failed reset destroys the object
+	testutil.CheckErr(t, p.Put(c0))
+	testutil.CheckEqual(t, p.Put(c1) != nil, true, "failed reset")
+	testutil.CheckEqual(t, resets, 2, "resets")
+	testutil.CheckEqual(t, destroyed, []*testConn{c1}, "destroyed after failed reset")
+
+	// validation on borrow
+	c0.broken = true
+	c2, err := p.Get(ctx)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, c2.id, 2, "new object after invalid one")
+	testutil.CheckEqual(t, destroyed, []*testConn{c1, c0}, "destroyed after failed validation")
+
+	p.Put(c2)
+	testutil.CheckErr(t, p.Drain())
+	testutil.CheckEqual(t, destroyed, []*testConn{c1, c0, c2}, "destroyed after drain")
+	testutil.CheckEqual(t, p.Stats(), Stats{Creations: 3, Disposals: 3}, "stats")
+}
@@ -0,0 +1,382 @@
+package pool
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ugorji/go-common/errorutil"
+)
+
+// TotalLimitErr is returned when a health check cannot replenish a sub-pool,
+// because KeyedOptions.MaxTotal objects are already live.
+var TotalLimitErr = errorutil.String("pool: total limit reached")
+
+// UnknownKeyErr is returned by Keyed.Put for a key which has no sub-pool.
+var UnknownKeyErr = errorutil.String("pool: unknown key")
+
+// KeyedOptions configures a Keyed pool.
+type KeyedOptions struct {
+	// Options configures each sub-pool. Its MaxActive is the maximum per key.
+	Options
+	// Capacity is the maximum number of idle objects per key.
+	Capacity int
+	// MaxTotal is the maximum number of live objects (borrowed or idle) across all keys.
+	// When reached, the oldest idle object (of any key) is destroyed to make room,
+	// else Get waits (in FIFO order) for an object to be destroyed.
+	// If 0, there is no limit.
+	MaxTotal int
+	// IdlePoolTimeout is how long a sub-pool with no borrowed object is kept after its last use.
+	// It is then drained and removed. If 0, sub-pools are never evicted.
+	IdlePoolTimeout time.Duration
+}
+
+// Keyed is a pool of objects partitioned by key (e.g. connections per backend host).
+//
+// A sub-pool (a Pool[V]) is created lazily for each key, using the hooks
+// returned by the newHooks function passed to NewKeyed. All sub-pools share
+// the limits of the KeyedOptions.
+//
+// If Options.HealthCheckInterval (or IdlePoolTimeout) is set, a single background
+// loop checks the health of the sub-pools and evicts the idle ones, at that interval.
+// Close must then be called to stop it.
+type Keyed[K comparable, V any] struct {
+	mu       sync.Mutex
+	pools    map[K]*keyedPool[V]
+	newHooks func(key K) Hooks[V]
+	opts     KeyedOptions
+	live     int
+	waiters  list.List // of *keyedWaiter, in FIFO order
+	closeC   chan struct{}
+	closed   bool
+}
+
+type keyedPool[V any] struct {
+	p    *Pool[V]
+	refs int       // borrowed objects, and calls to Get in progress
+	used time.Time // last call to Get or Put
+}
+
+// keyedWaiter is a call to Get waiting for a live object slot (MaxTotal was reached).
+type keyedWaiter struct {
+	c      chan struct{}
+	handed bool // set (under the lock) when removed from the queue and given a slot
+}
+
+// NewKeyed creates a new Keyed pool. newHooks returns the hooks of the sub-pool for a key.
+func NewKeyed[K comparable, V any](newHooks func(key K) Hooks[V], opts KeyedOptions) *Keyed[K, V] {
+	k := &Keyed[K, V]{
+		pools:    make(map[K]*keyedPool[V]),
+		newHooks: newHooks,
+		opts:     opts,
+	}
+	if d := k.interval(); d > 0 {
+		k.closeC = make(chan struct{})
+		go k.loop(d)
+	}
+	return k
+}
+
+func (k *Keyed[K, V]) interval() time.Duration {
+	if k.opts.HealthCheckInterval > 0 {
+		return k.opts.HealthCheckInterval
+	}
+	return k.opts.IdlePoolTimeout
+}
+
+func (k *Keyed[K, V]) now() time.Time {
+	if k.opts.Now != nil {
+		return k.opts.Now()
+	}
+	return time.Now()
+}
+
+// ref returns the sub-pool for the key (creating it if needed), marking it in use.
+func (k *Keyed[K, V]) ref(key K) (e *keyedPool[V]) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if e = k.pools[key]; e == nil {
+		opts := k.opts.Options
+		opts.HealthCheckInterval = 0 // checked by the loop of k
+		e = &keyedPool[V]{p: newPool(k.hooks(key), k.opts.Capacity, opts)}
+		k.pools[key] = e
+	}
+	e.refs++
+	e.used = k.now()
+	return
+}
+
+func (k *Keyed[K, V]) unref(e *keyedPool[V]) {
+	k.mu.Lock()
+	e.refs--
+	e.used = k.now()
+	k.mu.Unlock()
+}
+
+// hooks wraps the hooks of the key, to count the live objects against MaxTotal.
+func (k *Keyed[K, V]) hooks(key K) (h Hooks[V]) {
+	h = k.newHooks(key)
+	create, destroy := h.Create, h.Destroy
+	h.Create = func(ctx context.Context) (v V, err error) {
+		if err = k.acquire(ctx); err != nil {
+			return
+		}
+		if v, err = create(ctx); err != nil {
+			k.release()
+		}
+		return
+	}
+	h.Destroy = func(v V) (err error) {
+		if destroy != nil {
+			err = destroy(v)
+		}
+		k.release()
+		return
+	}
+	return
+}
+
+// acquire takes a live object slot, destroying the oldest idle object
+// or waiting for a slot if MaxTotal is reached.
+func (k *Keyed[K, V]) acquire(ctx context.Context) (err error) {
+	for {
+		k.mu.Lock()
+		if k.opts.MaxTotal <= 0 || k.live < k.opts.MaxTotal {
+			k.live++
+			k.mu.Unlock()
+			return
+		}
+		if victim := k.oldestIdleLocked(); victim != nil {
+			k.mu.Unlock()
+			if v, ok := victim.takeIdle(); ok {
+				victim.destroy(v)
+			}
+			continue
+		}
+		if ctx.Value(replenishKey{}) != nil {
+			k.mu.Unlock()
+			return TotalLimitErr
+		}
+		w := &keyedWaiter{c: make(chan struct{}, 1)}
+		e := k.waiters.PushBack(w)
+		k.mu.Unlock()
+		select {
+		case <-w.c:
+		case <-ctx.Done():
+			k.mu.Lock()
+			if w.handed {
+				// handed a slot just before giving up: pass it on
+				k.releaseLocked()
+			} else {
+				k.waiters.Remove(e)
+			}
+			k.mu.Unlock()
+			err = ctx.Err()
+		}
+		return
+	}
+}
+
+// oldestIdleLocked returns the sub-pool holding the oldest idle object (or nil if none).
+func (k *Keyed[K, V]) oldestIdleLocked() (victim *Pool[V]) {
+	var oldest time.Time
+	for _, e := range k.pools {
+		if since, ok := e.p.oldestIdle(); ok && (victim == nil || since.Before(oldest)) {
+			victim, oldest = e.p, since
+		}
+	}
+	return
+}
+
+// release gives up a live object slot, passing it to the first waiter.
+func (k *Keyed[K, V]) release() {
+	k.mu.Lock()
+	k.releaseLocked()
+	k.mu.Unlock()
+}
+
+func (k *Keyed[K, V]) releaseLocked() {
+	if e := k.waiters.Front(); e != nil {
+		w := k.waiters.Remove(e).(*keyedWaiter)
+		w.handed = true
+		w.c <- struct{}{}
+		return
+	}
+	if k.live > 0 {
+		k.live--
+	}
+}
+
+// Get returns an object for the key, from its sub-pool (see Pool.Get).
+//
+// If KeyedOptions.MaxTotal is reached, the oldest idle object of any key is destroyed
+// to make room; if there is none, it waits until an object is destroyed or the context is done.
+func (k *Keyed[K, V]) Get(ctx context.Context, key K) (v V, err error) {
+	e := k.ref(key)
+	if v, err = e.p.Get(ctx); err != nil {
+		k.unref(e)
+	}
+	return
+}
+
+// Put returns an object borrowed (via Get) for the key (see Pool.Put).
+//
+// If the key has no sub-pool, the object is destroyed (freeing its slot in MaxTotal),
+// and UnknownKeyErr is returned.
+func (k *Keyed[K, V]) Put(key K, v V) (err error) {
+	k.mu.Lock()
+	e := k.pools[key]
+	k.mu.Unlock()
+	if e == nil {
+		if destroy := k.newHooks(key).Destroy; destroy != nil {
+			err = destroy(v)
+		}
+		k.release()
+		return errorutil.Multi{UnknownKeyErr, err}.NonNilError()
+	}
+	err = e.p.Put(v)
+	k.unref(e)
+	k.evictForWaiters()
+	return
+}
+
+// evictForWaiters destroys idle objects (oldest first) while calls to Get wait
+// for a live object slot, as destroying them passes on their slots.
+func (k *Keyed[K, V]) evictForWaiters() {
+	for {
+		var victim *Pool[V]
+		k.mu.Lock()
+		if k.waiters.Len() > 0 {
+			victim = k.oldestIdleLocked()
+		}
+		k.mu.Unlock()
+		if victim == nil {
+			return
+		}
+		if v, ok := victim.takeIdle(); ok {
+			victim.destroy(v)
+		}
+	}
+}
+
+// Len returns the number of sub-pools.
+func (k *Keyed[K, V]) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.pools)
+}
+
+// Live returns the number of live objects (borrowed or idle) across all keys.
+func (k *Keyed[K, V]) Live() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.live
+}
+
+// Stats returns the current state and counters of the sub-pool for the key
+// (or zero Stats if it has none).
+func (k *Keyed[K, V]) Stats(key K) (s Stats) {
+	k.mu.Lock()
+	e := k.pools[key]
+	k.mu.Unlock()
+	if e != nil {
+		s = e.p.Stats()
+	}
+	return
+}
+
+// Evict drains and removes the sub-pools with no borrowed object,
+// which were not used for KeyedOptions.IdlePoolTimeout.
+//
+// It is called periodically by the background loop.
+func (k *Keyed[K, V]) Evict() error {
+	if k.opts.IdlePoolTimeout <= 0 {
+		return nil
+	}
+	now := k.now()
+	var ps []*Pool[V]
+	k.mu.Lock()
+	for key, e := range k.pools {
+		if e.refs == 0 && now.Sub(e.used) >= k.opts.IdlePoolTimeout {
+			ps = append(ps, e.p)
+			delete(k.pools, key)
+		}
+	}
+	k.mu.Unlock()
+	return drainAll(ps)
+}
+
+// Drain destroys the idle objects of all the sub-pools, and removes the sub-pools
+// with no borrowed object. The errors of all the sub-pools are aggregated.
+func (k *Keyed[K, V]) Drain() error {
+	var ps []*Pool[V]
+	k.mu.Lock()
+	for key, e := range k.pools {
+		ps = append(ps, e.p)
+		if e.refs == 0 {
+			delete(k.pools, key)
+		}
+	}
+	k.mu.Unlock()
+	return drainAll(ps)
+}
+
+func drainAll[V any](ps []*Pool[V]) error {
+	var merr errorutil.Multi
+	for _, p := range ps {
+		if err := p.Drain(); err != nil {
+			merr = append(merr, err)
+		}
+	}
+	return merr.NonNilError()
+}
+
+// HealthCheck checks the health of every sub-pool (see Pool.HealthCheck),
+// then evicts the idle sub-pools (see Evict).
+//
+// It is called periodically by the background loop.
+func (k *Keyed[K, V]) HealthCheck() error {
+	var ps []*Pool[V]
+	k.mu.Lock()
+	for _, e := range k.pools {
+		ps = append(ps, e.p)
+	}
+	k.mu.Unlock()
+	var merr errorutil.Multi
+	for _, p := range ps {
+		if err := p.HealthCheck(); err != nil {
+			merr = append(merr, err)
+		}
+	}
+	if err := k.Evict(); err != nil {
+		merr = append(merr, err)
+	}
+	return merr.NonNilError()
+}
+
+// Close stops the background loop (if any).
+//
+// The pool can still be used afterwards. Call Drain to destroy the idle objects.
+func (k *Keyed[K, V]) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.closeC != nil && !k.closed {
+		k.closed = true
+		close(k.closeC)
+	}
+	return nil
+}
+
+func (k *Keyed[K, V]) loop(d time.Duration) {
+	tick := time.NewTicker(d)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			k.HealthCheck()
+		case <-k.closeC:
+			return
+		}
+	}
+}
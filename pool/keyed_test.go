@@ -0,0 +1,185 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+// testHosts creates the hooks of a Keyed pool of testConn per host.
+type testHosts struct {
+	mu        sync.Mutex
+	created   map[string]int
+	destroyed map[string]int
+	failClose string // Destroy fails for this host
+}
+
+func (x *testHosts) hooks(host string) Hooks[*testConn] {
+	return Hooks[*testConn]{
+		Create: func(ctx context.Context) (*testConn, error) {
+			x.mu.Lock()
+			defer x.mu.Unlock()
+			x.created[host]++
+			return &testConn{id: x.created[host]}, nil
+		},
+		Destroy: func(c *testConn) error {
+			x.mu.Lock()
+			defer x.mu.Unlock()
+			c.disposed = true
+			x.destroyed[host]++
+			if host == x.failClose {
+				return errors.New("cannot close " + host)
+			}
+			return nil
+		},
+	}
+}
+
+func (x *testHosts) counts(m map[string]int) map[string]int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	m2 := make(map[string]int, len(m))
+	for k, v := range m {
+		m2[k] = v
+	}
+	return m2
+}
+
+func newTestHosts() *testHosts {
+	return &testHosts{created: make(map[string]int), destroyed: make(map[string]int)}
+}
+
+func TestKeyedPool(t *testing.T) {
+	ctx := context.Background()
+	x := newTestHosts()
+	clock := &testClock{t: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	k := NewKeyed(x.hooks, KeyedOptions{
+		Options:         Options{MaxActive: 2, Now: clock.Now},
+		Capacity:        2,
+		MaxTotal:        3,
+		IdlePoolTimeout: time.Minute,
+	})
+	defer k.Close()
+
+	a1 := Must(k.Get(ctx, "a")).(*testConn)
+	a2 := Must(k.Get(ctx, "a")).(*testConn)
+	b1 := Must(k.Get(ctx, "b")).(*testConn)
+	testutil.CheckEqual(t, k.Len(), 2, "sub-pools")
+	testutil.CheckEqual(t, k.Live(), 3, "live")
+
+	// per-key max
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	_, err := k.Get(ctx2, "a")
+	cancel()
+	testutil.CheckEqual(t, errors.Is(err, context.DeadlineExceeded), true, "per-key max")
+
+	// total max: wait until an object is destroyed
+	done := make(chan *testConn)
+	go func() {
+		c, err := k.Get(ctx, "c")
+		if err != nil {
+			t.Error(err)
+		}
+		done <- c
+	}()
+	time.Sleep(10 * time.Millisecond)
+	testutil.CheckEqual(t, x.counts(x.created)["c"], 0, "waiting for total max")
+	testutil.CheckErr(t, k.Put("a", a1))
+	// the returned object of a is destroyed to make room for c
+	c1 := <-done
+	testutil.CheckEqual(t, a1.disposed, true, "oldest idle destroyed")
+	testutil.CheckEqual(t, x.counts(x.created)["c"], 1, "created c")
+	testutil.CheckEqual(t, k.Live(), 3, "live")
+
+	testutil.CheckErr(t, k.Put("a", a2))
+	testutil.CheckErr(t, k.Put("c", c1))
+
+	// eviction of idle sub-pools
+	clock.Advance(30 * time.Second)
+	testutil.CheckErr(t, k.Put("b", b1))
+	clock.Advance(45 * time.Second)
+	testutil.CheckErr(t, k.HealthCheck())
+	testutil.CheckEqual(t, k.Len(), 1, "sub-pools after eviction")
+	testutil.CheckEqual(t, []bool{a2.disposed, b1.disposed, c1.disposed}, []bool{true, false, true}, "disposed")
+	testutil.CheckEqual(t, k.Live(), 1, "live after eviction")
+	testutil.CheckEqual(t, k.Stats("a"), Stats{}, "stats of evicted sub-pool")
+}
+
+func TestKeyedPoolUnknownKey(t *testing.T) {
+	ctx := context.Background()
+	x := newTestHosts()
+	k := NewKeyed(x.hooks, KeyedOptions{MaxTotal: 1})
+	defer k.Close()
+	a1 := Must(k.Get(ctx, "a")).(*testConn)
+	testutil.CheckEqual(t, k.Put("b", a1), UnknownKeyErr, "unknown key")
+	testutil.CheckEqual(t, a1.disposed, true, "disposed")
+	testutil.CheckEqual(t, k.Live(), 0, "live")
+	// the slot is free for another key
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err := k.Get(ctx2, "b")
+	testutil.CheckErr(t, err)
+}
+
+func TestKeyedPoolDrain(t *testing.T) {
+	ctx := context.Background()
+	x := newTestHosts()
+	x.failClose = "b"
+	k := NewKeyed(x.hooks, KeyedOptions{Capacity: 4})
+	var cs []*testConn
+	for _, host := range []string{"a", "b", "b", "c"} {
+		cs = append(cs, Must(k.Get(ctx, host)).(*testConn))
+	}
+	for i, host := range []string{"a", "b", "b"} {
+		testutil.CheckErr(t, k.Put(host, cs[i]))
+	}
+	err := k.Drain()
+	testutil.CheckEqual(t, err != nil, true, "drain error")
+	testutil.Log(t, "drain error: %v", err)
+	testutil.CheckEqual(t, x.counts(x.destroyed), map[string]int{"a": 1, "b": 2}, "destroyed")
+	// c still has a borrowed object, so its sub-pool is kept
+	testutil.CheckEqual(t, k.Len(), 1, "sub-pools after drain")
+	testutil.CheckErr(t, k.Put("c", cs[3]))
+	testutil.CheckErr(t, k.Drain())
+	testutil.CheckEqual(t, k.Len(), 0, "sub-pools after second drain")
+	testutil.CheckEqual(t, k.Live(), 0, "live")
+}
+
+func TestKeyedPoolConcurrent(t *testing.T) {
+	ctx := context.Background()
+	x := newTestHosts()
+	k := NewKeyed(x.hooks, KeyedOptions{
+		Options:  Options{MaxActive: 2},
+		Capacity: 1,
+		MaxTotal: 3,
+	})
+	hosts := []string{"a", "b", "c", "d"}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				host := hosts[(i+j)%len(hosts)]
+				c, err := k.Get(ctx, host)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if n := k.Live(); n > 3 {
+					t.Errorf("live: %d > 3", n)
+				}
+				k.Put(host, c)
+			}
+		}(i)
+	}
+	wg.Wait()
+	testutil.CheckErr(t, k.Drain())
+	testutil.CheckEqual(t, k.Live(), 0, "live after drain")
+	created, destroyed := x.counts(x.created), x.counts(x.destroyed)
+	testutil.CheckEqual(t, created, destroyed, "all destroyed")
+}
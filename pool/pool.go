@@ -1,7 +1,7 @@
 package pool
 
 import (
-	"sync"
+	"context"
 	"time"
 
 	"github.com/ugorji/go-common/errorutil"
@@ -13,18 +13,59 @@ const (
 	GET Action = iota
 	PUT
 	DISPOSE
+	// VALIDATE checks an idle object (on borrow, or during a health check).
+	// fn should return nil (or an error) if the object is no longer usable.
+	VALIDATE
 )
 
 type Fn func(v interface{}, a Action, currentLen int) (interface{}, error)
 
+// Options configures the limits of a pool (T or Pool), and the health of its objects.
+type Options struct {
+	// MaxActive is the maximum number of objects borrowed (via Get) at any time.
+	// When reached, Get waits (in FIFO order) for an object to be returned.
+	// If 0, there is no limit.
+	MaxActive int
+	// ValidateOnBorrow calls fn with VALIDATE on an idle object before returning it from Get.
+	ValidateOnBorrow bool
+	// MaxIdleTime is how long an object can stay idle in the pool before it is disposed.
+	MaxIdleTime time.Duration
+	// MaxLifetime is how long an object can be used (from its creation) before it is disposed.
+	// It is only tracked for objects which can be map keys (e.g. pointers).
+	MaxLifetime time.Duration
+	// MinIdle is the number of idle objects which the health check keeps in the pool.
+	// It is at most the capacity of the pool.
+	MinIdle int
+	// HealthCheckInterval is how often the idle objects are checked in the background:
+	// expired ones are disposed, the rest are validated, and MinIdle is replenished.
+	// If 0, there is no background health check.
+	HealthCheckInterval time.Duration
+	// Now returns the current time. If nil, time.Now is used. It is mostly useful for tests.
+	Now func() time.Time
+}
+
+// Stats holds the state and counters of a pool.
+type Stats struct {
+	Active    int           // objects currently borrowed
+	Idle      int           // objects currently idle in the pool
+	Waits     uint64        // calls to Get which had to wait (because MaxActive was reached)
+	WaitTime  time.Duration // total time spent waiting
+	Timeouts  uint64        // waits which ended because the context was done
+	Creations uint64        // objects created
+	Disposals uint64        // objects disposed
+}
+
+// errNoValue is returned by the Create hook of a T, when fn returns no value.
+var errNoValue = errorutil.String("pool: no value")
+
 // T is a simple structure that maintains a pool.
 // It offloads the heavy duty of whether a value should be returned
 // to the fn function.
 // This way, a user can determine when/how objects are returned.
+//
+// It is a Pool[interface{}], whose hooks call fn with the corresponding Action.
 type T struct {
-	vs []interface{}
-	mu sync.Mutex
-	ch chan interface{}
+	p  *Pool[interface{}]
 	fn Fn
 }
 
@@ -33,18 +74,33 @@ type T struct {
 //         allows user to create new object or modify one.
 //   - initCapacity: preloads this many by calling Get and Put that many times.
 func New(fn Fn, load, capacity int) (t *T, err error) {
-	if capacity < 1 {
-		capacity = 1
-	}
-	if load < 0 {
-		load = 0
-	} else if load > capacity {
-		load = capacity
-	}
-	t = &T{
-		fn: fn,
-		ch: make(chan interface{}, capacity),
+	return NewWithOptions(fn, load, capacity, Options{})
+}
+
+// NewWithOptions creates a new Pool, which manages the health of its objects
+// as configured by the Options. capacity is the maximum number of idle objects.
+//
+// If opts.HealthCheckInterval is set, Close must be called to stop the background health check.
+func NewWithOptions(fn Fn, load, capacity int, opts Options) (t *T, err error) {
+	t = &T{fn: fn}
+	hooks := Hooks[interface{}]{
+		Create: func(ctx context.Context) (v interface{}, err error) {
+			if v, err = fn(nil, GET, t.Len()); err == nil && v == nil {
+				err = errNoValue
+			}
+			return
+		},
+		Validate: func(v interface{}) bool {
+			v, err := fn(v, VALIDATE, t.Len())
+			return err == nil && v != nil
+		},
+		Destroy: func(v interface{}) (err error) {
+			_, err = fn(v, DISPOSE, t.Len())
+			return
+		},
 	}
+	t.p = newPool(hooks, capacity, opts)
+	load = t.p.clampLoad(load)
 
 	vs := make([]interface{}, load)
 
@@ -56,6 +112,7 @@ func New(fn Fn, load, capacity int) (t *T, err error) {
 	for i := 0; i < load; i++ {
 		t.Put(vs[i])
 	}
+	t.p.startHealthCheck()
 	return t, nil
 }
 
@@ -66,37 +123,90 @@ func Must(v interface{}, err error) interface{} {
 	return v
 }
 
+// Get returns an object from the pool, waiting up to t if Options.MaxActive is reached.
+//
+// It does not wait if MaxActive is 0 (or not reached), but creates an object when none is idle.
+// If MaxActive is reached, Get(0) fails at once with context.DeadlineExceeded.
+//
+// See GetContext.
 func (p *T) Get(t time.Duration) (v interface{}, err error) {
-	select {
-	case v = <-p.ch:
-	case <-time.After(t):
+	ctx, cancel := context.WithTimeout(context.Background(), t)
+	defer cancel()
+	return p.GetContext(ctx)
+}
+
+// GetContext returns an idle object from the pool (after calling fn with GET on it).
+// If none is available, fn is called with a nil value (to create one).
+//
+// See Pool.Get for the details.
+func (p *T) GetContext(ctx context.Context) (v interface{}, err error) {
+	v, created, err := p.p.get(ctx)
+	if err == errNoValue {
+		return nil, nil
+	}
+	if err != nil || created {
+		return
+	}
+	v0 := v
+	if v, err = p.fn(v0, GET, p.Len()); err != nil || v == nil {
+		// the idle object was dropped by fn
+		p.p.release()
+		p.p.untrack(v0)
+	} else if !sameValue(v, v0) {
+		// it was replaced: the new object's creation time is unknown
+		p.p.untrack(v0)
 	}
-	return p.fn(v, GET, len(p.ch))
+	return
+}
+
+// sameValue returns true if a and b are the same object (false if they are not comparable).
+func sameValue(a, b interface{}) bool {
+	return trackable(a) && trackable(b) && a == b
 }
 
+// Put returns a borrowed object to the pool.
+//
+// It is handed to the first waiting Get (if any), else kept idle.
+// If the pool is full (or the object expired), it is disposed.
+// If fn returns nil for PUT, the object is dropped (and no longer counted as active).
 func (p *T) Put(v interface{}) (err error) {
-	if v, err = p.fn(v, PUT, len(p.vs)); err != nil || v == nil {
+	v0 := v
+	if v, err = p.fn(v0, PUT, p.Len()); err != nil || v == nil {
+		p.p.release()
+		p.p.untrack(v0)
 		return
 	}
-	select {
-	case p.ch <- v:
-	default:
+	if !sameValue(v, v0) {
+		p.p.untrack(v0)
 	}
-	return
+	return p.p.put(v)
+}
+
+// Len returns the number of idle objects.
+func (p *T) Len() int {
+	return p.p.Len()
+}
+
+// Stats returns the current state and counters of the pool.
+func (p *T) Stats() Stats {
+	return p.p.Stats()
 }
 
 func (p *T) Drain() error {
-	var merr errorutil.Multi
-LOOP:
-	for len(p.ch) > 0 {
-		select {
-		case v := <-p.ch:
-			if _, err := p.fn(v, DISPOSE, 0); err != nil {
-				merr = append(merr, err)
-			}
-		default:
-			break LOOP
-		}
-	}
-	return merr.NonNilError()
+	return p.p.Drain()
+}
+
+// Close stops the background health check (if any).
+//
+// The pool can still be used afterwards. Call Drain to dispose the idle objects.
+func (p *T) Close() error {
+	return p.p.Close()
+}
+
+// HealthCheck checks the idle objects: expired ones are disposed,
+// the rest are validated, and the pool is replenished up to Options.MinIdle.
+//
+// It is called periodically if Options.HealthCheckInterval is set.
+func (p *T) HealthCheck() error {
+	return p.p.HealthCheck()
 }
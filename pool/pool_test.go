@@ -0,0 +1,276 @@
+package pool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// testConn is a pooled object, which tracks its lifecycle.
+type testConn struct {
+	id       int
+	broken   bool
+	disposed bool
+}
+
+type testConns struct {
+	mu      sync.Mutex
+	created []*testConn
+}
+
+func (x *testConns) fn(v interface{}, a Action, _ int) (interface{}, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	switch a {
+	case GET:
+		if v == nil {
+			c := &testConn{id: len(x.created)}
+			x.created = append(x.created, c)
+			v = c
+		}
+	case VALIDATE:
+		if v.(*testConn).broken {
+			return nil, nil
+		}
+	case DISPOSE:
+		v.(*testConn).disposed = true
+	}
+	return v, nil
+}
+
+func (x *testConns) numCreated() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.created)
+}
+
+func TestPool(t *testing.T) {
+	var x testConns
+	p, err := New(x.fn, 2, 4)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, x.numCreated(), 2, "preloaded")
+	c1 := Must(p.Get(0)).(*testConn)
+	c2 := Must(p.Get(0)).(*testConn)
+	c3 := Must(p.Get(0)).(*testConn)
+	testutil.CheckEqual(t, x.numCreated(), 3, "created")
+	p.Put(c1)
+	p.Put(c2)
+	p.Put(c3)
+	testutil.CheckErr(t, p.Drain())
+	testutil.CheckEqual(t, []bool{c1.disposed, c2.disposed, c3.disposed}, []bool{true, true, true}, "disposed")
+}
+
+func TestPoolHealth(t *testing.T) {
+	var x testConns
+	clock := &testClock{t: time.Unix(1000, 0)}
+	p, err := NewWithOptions(x.fn, 0, 4, Options{
+		ValidateOnBorrow: true,
+		MaxIdleTime:      time.Minute,
+		MaxLifetime:      time.Hour,
+		MinIdle:          2,
+		Now:              clock.Now,
+	})
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, x.numCreated(), 2, "preloaded to min idle")
+
+	// validation on borrow skips (and disposes) broken objects
+	c0 := Must(p.Get(0)).(*testConn)
+	c0.broken = true
+	p.Put(c0)
+	c1 := Must(p.Get(0)).(*testConn)
+	c2 := Must(p.Get(0)).(*testConn)
+	testutil.CheckEqual(t, c0.disposed, true, "broken disposed")
+	testutil.CheckEqual(t, c1 != c0 && c2 != c0, true, "broken skipped")
+
+	// max idle time
+	p.Put(c1)
+	clock.Advance(2 * time.Minute)
+	c3 := Must(p.Get(0)).(*testConn)
+	testutil.CheckEqual(t, c1.disposed, true, "idle too long disposed")
+	testutil.CheckEqual(t, c3 != c1, true, "idle too long skipped")
+
+	// max lifetime: c2 was created more than an hour ago
+	clock.Advance(time.Hour)
+	p.Put(c2)
+	testutil.CheckEqual(t, c2.disposed, true, "too old disposed on put")
+
+	// health check disposes broken idle objects, and replenishes min idle
+	p.Put(c3)
+	c3.broken = true
+	n := x.numCreated()
+	testutil.CheckErr(t, p.HealthCheck())
+	testutil.CheckEqual(t, c3.disposed, true, "health check disposed broken")
+	testutil.CheckEqual(t, x.numCreated(), n+2, "health check replenished")
+	testutil.CheckEqual(t, p.Len(), 2, "idle after health check")
+}
+
+func TestPoolDropUntracks(t *testing.T) {
+	var x testConns
+	drop := func(v interface{}, a Action, n int) (interface{}, error) {
+		if a == PUT {
+			return nil, nil
+		}
+		return x.fn(v, a, n)
+	}
+	p, err := NewWithOptions(drop, 0, 4, Options{MaxLifetime: time.Hour})
+	testutil.CheckErr(t, err)
+	for i := 0; i < 3; i++ {
+		testutil.CheckErr(t, p.Put(Must(p.Get(0))))
+	}
+	testutil.CheckEqual(t, x.numCreated(), 3, "created")
+	testutil.CheckEqual(t, len(p.p.born), 0, "tracked after drop")
+	testutil.CheckEqual(t, p.Stats().Active, 0, "active after drop")
+}
+
+func TestPoolMinIdleOverCapacity(t *testing.T) {
+	var x testConns
+	p, err := NewWithOptions(x.fn, 0, 2, Options{MinIdle: 5})
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, x.numCreated(), 2, "preloaded to capacity")
+	c := Must(p.Get(0)).(*testConn)
+	c.broken = true
+	p.Put(c)
+	testutil.CheckErr(t, p.HealthCheck())
+	testutil.CheckEqual(t, x.numCreated(), 3, "replenished to capacity")
+	testutil.CheckEqual(t, p.Len(), 2, "idle")
+	testutil.CheckEqual(t, p.Stats().Disposals, uint64(1), "disposals")
+}
+
+func TestPoolHealthCheckKeepsIdle(t *testing.T) {
+	var x testConns
+	var p *T
+	var got interface{}
+	// a Get during the check is served from the idle objects not being validated
+	check := func(v interface{}, a Action, n int) (interface{}, error) {
+		if a == VALIDATE && got == nil {
+			got = Must(p.Get(0))
+		}
+		return x.fn(v, a, n)
+	}
+	p, err := NewWithOptions(check, 2, 4, Options{})
+	testutil.CheckErr(t, err)
+	testutil.CheckErr(t, p.HealthCheck())
+	testutil.CheckEqual(t, x.numCreated(), 2, "created")
+	testutil.CheckEqual(t, got != nil, true, "borrowed during check")
+	testutil.CheckEqual(t, p.Len(), 1, "idle")
+}
+
+func TestPoolHealthLoop(t *testing.T) {
+	var x testConns
+	p, err := NewWithOptions(x.fn, 0, 4, Options{MinIdle: 1, HealthCheckInterval: time.Millisecond})
+	testutil.CheckErr(t, err)
+	defer p.Close()
+	c := Must(p.Get(0)).(*testConn)
+	c.broken = true
+	p.Put(c)
+	for i := 0; i < 1000 && !func() bool { x.mu.Lock(); defer x.mu.Unlock(); return c.disposed }(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	x.mu.Lock()
+	testutil.CheckEqual(t, c.disposed, true, "background health check disposed broken")
+	x.mu.Unlock()
+}
+
+func TestPoolBounded(t *testing.T) {
+	var x testConns
+	p, err := NewWithOptions(x.fn, 0, 1, Options{MaxActive: 2})
+	testutil.CheckErr(t, err)
+	c1 := Must(p.Get(0)).(*testConn)
+	c2 := Must(p.Get(0)).(*testConn)
+
+	// at the limit: wait until the timeout
+	_, err = p.Get(time.Millisecond)
+	testutil.CheckEqual(t, err, context.DeadlineExceeded, "timeout")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = p.GetContext(ctx)
+	testutil.CheckEqual(t, err, context.Canceled, "canceled")
+
+	// waiters are served in FIFO order
+	got := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			v, err := p.GetContext(context.Background())
+			testutil.CheckErr(t, err)
+			got <- i
+			p.Put(v)
+		}(i)
+		for p.Stats().Waits != uint64(3+i) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	p.Put(c1)
+	testutil.CheckEqual(t, <-got, 0, "first waiter")
+	testutil.CheckEqual(t, <-got, 1, "second waiter")
+	p.Put(c2) // the idle capacity is 1, so one of c1 or c2 is disposed
+
+	st := p.Stats()
+	st.WaitTime = 0
+	testutil.CheckEqual(t, st, Stats{Active: 0, Idle: 1, Waits: 4, Timeouts: 2, Creations: 2, Disposals: 1}, "stats")
+
+	// putting an object which was not borrowed does not make the active count negative
+	p.Put(&testConn{id: -1})
+	testutil.CheckEqual(t, p.Stats().Active, 0, "active after put of unborrowed")
+	Must(p.Get(0))
+	Must(p.Get(0))
+	_, err = p.Get(0)
+	testutil.CheckEqual(t, err, context.DeadlineExceeded, "limit after put of unborrowed")
+}
+
+func TestPoolBoundedConcurrent(t *testing.T) {
+	var x testConns
+	const max = 3
+	p, err := NewWithOptions(x.fn, 0, 2, Options{MaxActive: max})
+	testutil.CheckErr(t, err)
+	var mu sync.Mutex
+	var active, maxSeen int
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				v, err := p.GetContext(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if active++; active > maxSeen {
+					maxSeen = active
+				}
+				mu.Unlock()
+				time.Sleep(time.Microsecond)
+				mu.Lock()
+				active--
+				mu.Unlock()
+				p.Put(v)
+			}
+		}()
+	}
+	wg.Wait()
+	testutil.CheckEqual(t, maxSeen <= max, true, "max active")
+	st := p.Stats()
+	testutil.CheckEqual(t, st.Active, 0, "active at end")
+	testutil.CheckEqual(t, int(st.Creations-st.Disposals), st.Idle, "live objects are idle")
+}
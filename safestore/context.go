@@ -0,0 +1,94 @@
+package safestore
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey struct{}
+
+// Scope is a request-scoped store, which reads through to a parent store,
+// but writes locally.
+//
+// Get and Gets return the local value if set, else the parent's value.
+// All other methods (e.g. GetAll, Removes) only work on the local T.
+// Consequently, removing a key locally will make the parent's value (if any) visible again.
+type Scope struct {
+	*T
+	parent I
+}
+
+// NewScope returns a Scope over a new T, which reads through to parent (if not nil).
+func NewScope(parent I, useLock bool) *Scope {
+	return &Scope{New(useLock), parent}
+}
+
+// Parent returns the parent store of this Scope (or nil).
+func (s *Scope) Parent() I {
+	return s.parent
+}
+
+func (s *Scope) Get(key interface{}) (v interface{}) {
+	if v = s.T.Get(key); v == nil && s.parent != nil {
+		v = s.parent.Get(key)
+	}
+	return
+}
+
+func (s *Scope) Gets(keys ...interface{}) (v []interface{}) {
+	if v = s.T.Gets(keys...); s.parent == nil {
+		return
+	}
+	var pkeys []interface{}
+	for i := range v {
+		if v[i] == nil {
+			pkeys = append(pkeys, keys[i])
+		}
+	}
+	if len(pkeys) == 0 {
+		return
+	}
+	pvals := s.parent.Gets(pkeys...)
+	for i, j := 0, 0; i < len(v); i++ {
+		if v[i] == nil {
+			v[i] = pvals[j]
+			j++
+		}
+	}
+	return
+}
+
+// NewContext returns a copy of ctx which carries the store.
+func NewContext(ctx context.Context, s I) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the store carried by ctx, or nil if none.
+func FromContext(ctx context.Context) (s I) {
+	s, _ = ctx.Value(contextKey{}).(I)
+	return
+}
+
+// NewChildContext creates a Scope which reads through to the store carried by ctx (if any),
+// and returns a copy of ctx which carries the Scope.
+//
+// The caller should Close the Scope when done with it.
+func NewChildContext(ctx context.Context, useLock bool) (context.Context, *Scope) {
+	s := NewScope(FromContext(ctx), useLock)
+	return NewContext(ctx, s), s
+}
+
+// Middleware returns a http.Handler which creates a Scope for each request,
+// attaches it to the request's context, and closes it when the request is done.
+//
+// The Scope reads through to any store already carried by the request's context.
+// Use a lock-enabled Scope if the handler uses multiple goroutines per request.
+func Middleware(useLock bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, s := NewChildContext(r.Context(), useLock)
+		defer s.Close()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+var _ = I((*Scope)(nil))
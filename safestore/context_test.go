@@ -0,0 +1,34 @@
+package safestore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func TestContextScope(t *testing.T) {
+	app := New(true)
+	defer app.Close()
+	app.Put("site", "example", 0)
+	app.Put("user", "anonymous", 0)
+
+	var vals []interface{}
+	h := Middleware(false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := FromContext(r.Context())
+		s.Put("user", "ugorji", 0)
+		_, child := NewChildContext(r.Context(), false)
+		defer child.Close()
+		child.Put("page", 1, 0)
+		vals = child.Gets("site", "user", "page")
+	}))
+	ctx := NewContext(context.Background(), app)
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	testutil.CheckEqual(t, vals, []interface{}{"example", "ugorji", 1}, "values in child scope")
+	// writes in the request scope do not leak to the parent
+	testutil.CheckEqual(t, app.Get("user"), "anonymous", "parent value")
+}
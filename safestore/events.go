@@ -0,0 +1,176 @@
+package safestore
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Cause is the reason for a change to an entry in the store.
+type Cause uint8
+
+const (
+	CauseAdded    Cause = iota + 1 // a new key was stored
+	CauseReplaced                  // the value for an existing key was replaced (or updated in place via Incr)
+	CauseRemoved                   // the key was removed (via Removes, or by storing a nil value)
+	CauseExpired                   // the key was removed because its TTL passed
+	CauseEvicted                   // the key was evicted (or rejected) because the store was full
+)
+
+func (x Cause) String() string {
+	switch x {
+	case CauseAdded:
+		return "added"
+	case CauseReplaced:
+		return "replaced"
+	case CauseRemoved:
+		return "removed"
+	case CauseExpired:
+		return "expired"
+	case CauseEvicted:
+		return "evicted"
+	}
+	return "unknown"
+}
+
+// Event describes a change to an entry in the store.
+type Event struct {
+	Key interface{}
+	Old interface{} // value before the change (nil if added)
+	New interface{} // value after the change (nil if removed, expired or evicted)
+	Cause
+}
+
+// Subscription receives the Events for changes to a T.
+//
+// Events are delivered after the T is unlocked, so a listener can safely call back into the T.
+type Subscription struct {
+	// C receives the events, for a subscription created via SubscribeChan.
+	// It is closed when the Subscription is closed.
+	C <-chan Event
+
+	rq      *T
+	fn      func(Event)
+	c       chan Event
+	mu      sync.Mutex
+	closed  bool
+	dropped atomic.Uint64
+}
+
+// Subscribe calls fn for each change to the store.
+//
+// fn is called synchronously by the goroutine which made the change, after the lock is released.
+// Expired events are delivered by the goroutine which purged them (e.g. the background purge goroutine).
+func (rq *T) Subscribe(fn func(Event)) *Subscription {
+	s := &Subscription{rq: rq, fn: fn}
+	rq.subscribe(s)
+	return s
+}
+
+// SubscribeChan returns a Subscription which delivers events on a channel with the given buffer size.
+//
+// Sending never blocks. If the buffer is full, the event is dropped (see Subscription.Dropped).
+func (rq *T) SubscribeChan(size int) *Subscription {
+	c := make(chan Event, size)
+	s := &Subscription{rq: rq, c: c, C: c}
+	rq.subscribe(s)
+	return s
+}
+
+func (rq *T) subscribe(s *Subscription) {
+	rq.subMu.Lock()
+	defer rq.subMu.Unlock()
+	// copy-on-write, so dispatch can iterate without holding subMu
+	subs := make([]*Subscription, len(rq.subs), len(rq.subs)+1)
+	copy(subs, rq.subs)
+	rq.subs = append(subs, s)
+	rq.nsubs.Store(int32(len(rq.subs)))
+}
+
+// Close stops delivery of events to this Subscription.
+func (s *Subscription) Close() {
+	rq := s.rq
+	rq.subMu.Lock()
+	subs := make([]*Subscription, 0, len(rq.subs))
+	for _, s2 := range rq.subs {
+		if s2 != s {
+			subs = append(subs, s2)
+		}
+	}
+	rq.subs = subs
+	rq.nsubs.Store(int32(len(rq.subs)))
+	rq.subMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		if s.c != nil {
+			close(s.c)
+		}
+	}
+}
+
+// Dropped returns how many events were dropped because the channel buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription) send(ev Event) {
+	if s.fn != nil {
+		s.fn(ev)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.c <- ev:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// emit queues an event (while locked), if anyone is listening.
+//
+// Negative results cached by GetOrLoad are internal, and do not generate events.
+func (rq *T) emit(ev Event) {
+	if rq.nsubs.Load() == 0 && (ev.Cause != CauseEvicted || rq.opts.OnEvict == nil) {
+		return
+	}
+	if ev.Old == loadNotFound {
+		ev.Old = nil
+		if ev.Cause == CauseReplaced {
+			ev.Cause = CauseAdded
+		}
+	}
+	if ev.New == loadNotFound || (ev.Old == nil && ev.New == nil) {
+		return
+	}
+	rq.pending = append(rq.pending, ev)
+}
+
+// takeEvents returns the queued events. It must be called before the lock is released.
+func (rq *T) takeEvents() (events []Event) {
+	events, rq.pending = rq.pending, nil
+	return
+}
+
+// dispatch delivers the events to the listeners. It must be called after the lock is released.
+func (rq *T) dispatch(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	rq.subMu.Lock()
+	subs := rq.subs
+	rq.subMu.Unlock()
+	for _, ev := range events {
+		if ev.Cause == CauseEvicted && rq.opts.OnEvict != nil {
+			rq.opts.OnEvict(ev.Key, ev.Old)
+		}
+		for _, s := range subs {
+			s.send(ev)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package safestore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func TestSubscribe(t *testing.T) {
+	clock := &testClock{t: time.Unix(1000, 0)}
+	rq := NewWithOptions(Options{UseLock: true, MaxEntries: 2, Now: clock.Now})
+	defer rq.Close()
+
+	var events []Event
+	sub := rq.Subscribe(func(ev Event) {
+		events = append(events, ev)
+		// calling back into the store must not deadlock
+		rq.Get(ev.Key)
+	})
+	subc := rq.SubscribeChan(2)
+
+	rq.Put("a", 1, 0)
+	rq.Put("a", 2, 0)
+	rq.Put("b", 3, time.Second)
+	rq.Put("c", 4, 0) // evicts "a"
+	rq.Removes("c", "none")
+	clock.Advance(2 * time.Second)
+	rq.Purge()
+
+	testutil.CheckEqual(t, events, []Event{
+		{"a", nil, 1, CauseAdded},
+		{"a", 1, 2, CauseReplaced},
+		{"b", nil, 3, CauseAdded},
+		{"a", 2, nil, CauseEvicted},
+		{"c", nil, 4, CauseAdded},
+		{"c", 4, nil, CauseRemoved},
+		{"b", 3, nil, CauseExpired},
+	}, "events")
+
+	// only the first 2 fit into the channel buffer
+	testutil.CheckEqual(t, subc.Dropped(), uint64(5), "dropped events")
+	subc.Close()
+	var events2 []Event
+	for ev := range subc.C {
+		events2 = append(events2, ev)
+	}
+	testutil.CheckEqual(t, events2, events[:2], "channel events")
+
+	sub.Close()
+	rq.Put("d", 5, 0)
+	testutil.CheckEqual(t, len(events), 7, "events after Close")
+}
+
+// rejectPolicy is an LRU which never admits a new key into a full store.
+type rejectPolicy struct {
+	Policy
+}
+
+func (rejectPolicy) Admit(candidate, victim interface{}) bool { return false }
+
+func TestIncrEvents(t *testing.T) {
+	rq := NewWithOptions(Options{UseLock: true, MaxEntries: 1, Policy: rejectPolicy{NewLRU()}})
+	defer rq.Close()
+	type change struct {
+		Old, New interface{}
+		Cause
+	}
+	var changes []change
+	rq.Subscribe(func(ev Event) {
+		var c change
+		if x, ok := ev.Old.(*uint64); ok {
+			c.Old = *x
+		}
+		if x, ok := ev.New.(*uint64); ok {
+			c.New = *x
+		}
+		c.Cause = ev.Cause
+		changes = append(changes, c)
+	})
+	rq.Incr("a", 1, 0)
+	rq.Incr("a", 2, 0)
+	rq.Incr("b", 1, 0) // rejected
+	testutil.CheckEqual(t, changes, []change{
+		{nil, uint64(1), CauseAdded},
+		{uint64(1), uint64(3), CauseReplaced},
+	}, "events")
+}
@@ -0,0 +1,135 @@
+package safestore
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryHeap is a min-heap of the items with a TTL, ordered by when they should be removed.
+//
+// This gives O(log n) insertion and removal, and the next item to expire is always at the top,
+// so a purge only touches the items which have actually expired.
+type expiryHeap []*safestoreItem
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].removeTimeNs < h[j].removeTimeNs }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*safestoreItem)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.index = -1
+	*h = old[:len(old)-1]
+	return e
+}
+
+func (rq *T) now() time.Time {
+	if rq.opts.Now != nil {
+		return rq.opts.Now()
+	}
+	return time.Now()
+}
+
+func (rq *T) addExpiry(tt *safestoreItem) {
+	heap.Push(&rq.expiry, tt)
+	// wake up the purge goroutine if this is now the next item to expire
+	if rq.useLock && tt.index == 0 {
+		rq.purgeOnce.Do(rq.startPurgeLoop)
+		select {
+		case rq.wakeC <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (rq *T) removeExpiry(tt *safestoreItem) {
+	if tt.index >= 0 {
+		heap.Remove(&rq.expiry, tt.index)
+	}
+}
+
+// Purge removes all the expired entries from the store.
+//
+// It is called automatically: by a background goroutine (for a lock-enabled T),
+// and on each write. It is exported mostly for tests using an injected clock (see Options.Now).
+func (rq *T) Purge() {
+	rq.dispatch(rq.lockedPurge())
+}
+
+func (rq *T) lockedPurge() (events []Event) {
+	if rq.useLock {
+		rq.lock.Lock()
+		defer rq.lock.Unlock()
+	}
+	rq.purge(rq.now().UnixNano())
+	return rq.takeEvents()
+}
+
+func (rq *T) purge(ts int64) {
+	for len(rq.expiry) > 0 && ts >= rq.expiry[0].removeTimeNs {
+		rq.remove(rq.expiry[0].key, CauseExpired)
+	}
+}
+
+// nextExpiry returns how long until the next item expires, or -1 if there are no items with a TTL.
+func (rq *T) nextExpiry() time.Duration {
+	rq.lock.Lock()
+	defer rq.lock.Unlock()
+	if len(rq.expiry) == 0 {
+		return -1
+	}
+	d := time.Duration(rq.expiry[0].removeTimeNs - rq.now().UnixNano())
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// Close stops the background goroutine which purges expired entries.
+//
+// The T can still be used afterwards, but expired entries will only be purged during writes.
+func (rq *T) Close() error {
+	if rq.closeC == nil {
+		return nil
+	}
+	rq.closeOnce.Do(func() {
+		rq.purgeOnce.Do(func() {}) // never start the purge goroutine after this
+		close(rq.closeC)
+	})
+	return nil
+}
+
+func (rq *T) startPurgeLoop() {
+	go rq.purgeLoop()
+}
+
+func (rq *T) purgeLoop() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if d := rq.nextExpiry(); d >= 0 {
+			timer.Reset(d)
+		}
+		select {
+		case <-timer.C:
+			rq.Purge()
+		case <-rq.wakeC:
+		case <-rq.closeC:
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package safestore
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestExpiryClock(t *testing.T) {
+	for _, useLock := range []bool{true, false} {
+		clock := &testClock{t: time.Unix(1000, 0)}
+		rq := NewWithOptions(Options{UseLock: useLock, Now: clock.Now})
+		rq.Put("long", 1, time.Hour)
+		rq.Put("short", 2, time.Second)
+		rq.Put("mid", 3, time.Minute)
+		rq.Put("forever", 4, 0)
+
+		clock.Advance(2 * time.Second)
+		// expired values are never returned, even before they are purged
+		testutil.CheckEqual(t, rq.Gets("long", "short", "mid", "forever"),
+			[]interface{}{1, nil, 3, 4}, "values after 2s")
+		testutil.CheckEqual(t, rq.Len(), 4, "len before purge")
+		rq.Purge()
+		testutil.CheckEqual(t, rq.Len(), 3, "len after purge")
+
+		// replacing an entry with a TTL by one without, removes it from expiry
+		rq.Put("mid", 5, 0)
+		clock.Advance(2 * time.Minute)
+		rq.Purge()
+		testutil.CheckEqual(t, rq.Gets("long", "mid", "forever"), []interface{}{1, 5, 4}, "values after 2m")
+
+		// a write also purges expired entries
+		clock.Advance(time.Hour)
+		rq.Put("x", 6, 0)
+		testutil.CheckEqual(t, rq.Len(), 3, "len after write")
+		rq.Close()
+	}
+}
+
+func TestExpiryPurgeLoop(t *testing.T) {
+	rq := New(true)
+	// a long TTL first must not delay purging a short one
+	rq.Put("long", 1, time.Hour)
+	rq.Put("short", 2, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	testutil.CheckEqual(t, rq.Len(), 1, "len after short ttl")
+
+	// once closed, expired entries are only purged on writes
+	rq.Close()
+	rq.Put("short", 2, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	testutil.CheckEqual(t, rq.Len(), 2, "len after close")
+	testutil.CheckEqual(t, rq.Get("short"), nil, "expired value after close")
+	rq.Removes("none")
+	testutil.CheckEqual(t, rq.Len(), 1, "len after write")
+}
@@ -0,0 +1,175 @@
+package safestore
+
+import (
+	"fmt"
+	"time"
+)
+
+// Loader loads the value for a key, when it is not found in the store (see GetOrLoad).
+//
+// A nil value (with a nil error) means that the key was not found.
+type Loader func(key interface{}) (value interface{}, err error)
+
+// loadCall is an in-flight (or completed) call to a Loader for a key.
+type loadCall struct {
+	done chan struct{}
+	v    interface{}
+	err  error
+	// superseded is set (under loadMu) when the key is written while the load is in flight,
+	// so the loaded value (which may be older) is not stored over that write.
+	superseded bool
+}
+
+// loadNotFound is stored for keys which the Loader could not find (see Options.NegativeTTL).
+var loadNotFound = &struct{ _ byte }{}
+
+// GetOrLoad returns the value for the key, calling the loader if it is not in the store.
+//
+// Concurrent calls for the same key which miss are coalesced: only one calls the loader,
+// and all of them get its result (value or error). A loaded value is stored with the given ttl.
+// Errors (including a panic in the loader) are returned to all waiters, but not cached.
+//
+// If the loader finds nothing (nil value and nil error), GetOrLoad returns nil,
+// and caches that result for Options.NegativeTTL (if set).
+//
+// A Put or Remove of the key while the loader runs wins: the loaded value is returned,
+// but not stored.
+//
+// If Options.StaleTTL is set, a value past its ttl is still returned for up to StaleTTL,
+// while a single refresh is done in the background (stale-while-revalidate).
+// Errors of background refreshes are passed to Options.OnLoadError.
+// A T without a lock cannot be written from another goroutine, so it refreshes
+// synchronously instead: the refreshed value is returned (or, on error, the stale one).
+func (rq *T) GetOrLoad(key interface{}, loader Loader, ttl time.Duration) (v interface{}, err error) {
+	v, found, stale := rq.lookup(key)
+	if !found {
+		return rq.load(key, loader, ttl, false)
+	}
+	if stale && rq.useLock {
+		rq.load(key, loader, ttl, true)
+	} else if stale {
+		if v2, err2 := rq.load(key, loader, ttl, false); err2 != nil {
+			rq.loadFailed(key, err2)
+		} else {
+			v = v2
+		}
+	}
+	return
+}
+
+// loadFailed reports the error of a refresh, which is not returned to the caller.
+func (rq *T) loadFailed(key interface{}, err error) {
+	if rq.opts.OnLoadError != nil {
+		rq.opts.OnLoadError(key, err)
+	}
+}
+
+// lookup is find, but under the lock, and recording the access with the Policy.
+func (rq *T) lookup(key interface{}) (v interface{}, found, stale bool) {
+	if rq.useLock {
+		rq.rlock()
+		defer rq.runlock()
+	}
+	if v, found, stale = rq.find(key); found && rq.opts.Policy != nil {
+		rq.opts.Policy.Accessed(key)
+	}
+	return
+}
+
+// load calls the loader, unless a call for the same key is already in flight,
+// in which case it waits for that call's result (unless background is true).
+func (rq *T) load(key interface{}, loader Loader, ttl time.Duration, background bool) (v interface{}, err error) {
+	rq.loadMu.Lock()
+	c, inflight := rq.loads[key]
+	if !inflight {
+		if rq.loads == nil {
+			rq.loads = make(map[interface{}]*loadCall)
+		}
+		c = &loadCall{done: make(chan struct{})}
+		rq.loads[key] = c
+		rq.nloads.Add(1)
+	}
+	rq.loadMu.Unlock()
+	if background {
+		if !inflight {
+			go func() {
+				if rq.doLoad(key, c, loader, ttl); c.err != nil {
+					rq.loadFailed(key, c.err)
+				}
+			}()
+		}
+		return
+	}
+	if inflight {
+		<-c.done
+	} else {
+		rq.doLoad(key, c, loader, ttl)
+	}
+	return c.v, c.err
+}
+
+func (rq *T) doLoad(key interface{}, c *loadCall, loader Loader, ttl time.Duration) {
+	defer func() {
+		if x := recover(); x != nil {
+			c.v, c.err = nil, fmt.Errorf("safestore: panic loading %v: %v", key, x)
+		}
+		// the result is in the store (if cacheable) before new callers stop waiting on c
+		rq.loadMu.Lock()
+		delete(rq.loads, key)
+		rq.nloads.Add(-1)
+		rq.loadMu.Unlock()
+		close(c.done)
+	}()
+	if c.v, c.err = loader(key); c.err != nil {
+		return
+	}
+	if c.v == nil {
+		if rq.opts.NegativeTTL > 0 {
+			rq.dispatch(rq.lockedPutLoaded(c, key, loadNotFound, rq.opts.NegativeTTL, 0))
+		}
+		return
+	}
+	var stale time.Duration
+	if ttl > 0 {
+		stale = rq.opts.StaleTTL
+	}
+	rq.dispatch(rq.lockedPutLoaded(c, key, c.v, ttl, stale))
+}
+
+// supersedeLoad marks the in-flight load for the key (if any) as superseded by a write.
+// It is called under the lock, for every write of a key.
+func (rq *T) supersedeLoad(key interface{}) {
+	if rq.nloads.Load() == 0 {
+		// a load added after this check started after the write, so it is not superseded
+		return
+	}
+	rq.loadMu.Lock()
+	if c := rq.loads[key]; c != nil {
+		c.superseded = true
+	}
+	rq.loadMu.Unlock()
+}
+
+func (rq *T) lockedPutLoaded(c *loadCall, key interface{}, val interface{}, ttl, stale time.Duration) (events []Event) {
+	if rq.useLock {
+		rq.lock.Lock()
+		defer rq.lock.Unlock()
+	}
+	rq.loadMu.Lock()
+	superseded := c.superseded
+	rq.loadMu.Unlock()
+	if superseded {
+		return
+	}
+	ts := rq.now().UnixNano()
+	rq.purge(ts)
+	var removeTimeNs, freshTimeNs int64
+	if ttl > 0 {
+		removeTimeNs = ts + int64(ttl)
+		if stale > 0 {
+			freshTimeNs, removeTimeNs = removeTimeNs, removeTimeNs+int64(stale)
+		}
+	}
+	rq.putItem(key, val, removeTimeNs, freshTimeNs)
+	return rq.takeEvents()
+}
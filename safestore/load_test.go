@@ -0,0 +1,175 @@
+package safestore
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func TestGetOrLoadCoalesce(t *testing.T) {
+	rq := New(true)
+	defer rq.Close()
+	var calls int32
+	release := make(chan struct{})
+	loader := func(key interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "v-" + key.(string), nil
+	}
+	var wg sync.WaitGroup
+	vals := make([]interface{}, 8)
+	for i := range vals {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := rq.GetOrLoad("k", loader, time.Hour)
+			testutil.CheckErr(t, err)
+			vals[i] = v
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	testutil.CheckEqual(t, atomic.LoadInt32(&calls), int32(1), "number of loader calls")
+	for i := range vals {
+		testutil.CheckEqual(t, vals[i], "v-k", "loaded value")
+	}
+	testutil.CheckEqual(t, rq.Get("k"), "v-k", "stored value")
+
+	// errors are propagated, but not cached
+	errLoad := errors.New("load failed")
+	_, err := rq.GetOrLoad("e", func(key interface{}) (interface{}, error) { return nil, errLoad }, 0)
+	testutil.CheckEqual(t, err, errLoad, "loader error")
+	v, err := rq.GetOrLoad("e", func(key interface{}) (interface{}, error) { return 1, nil }, 0)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, v, 1, "value after error")
+}
+
+func TestGetOrLoadNegativeAndStale(t *testing.T) {
+	clock := &testClock{t: time.Unix(1000, 0)}
+	rq := NewWithOptions(Options{
+		UseLock:     true,
+		Now:         clock.Now,
+		NegativeTTL: time.Minute,
+		StaleTTL:    time.Minute,
+	})
+	defer rq.Close()
+	var calls int32
+	var result atomic.Value
+	result.Store("v1")
+	loaded := make(chan struct{}, 4)
+	loader := func(key interface{}) (v interface{}, err error) {
+		atomic.AddInt32(&calls, 1)
+		defer func() { loaded <- struct{}{} }()
+		if key == "missing" {
+			return
+		}
+		return result.Load(), nil
+	}
+
+	// negative results are cached for NegativeTTL
+	for i := 0; i < 2; i++ {
+		v, err := rq.GetOrLoad("missing", loader, time.Second)
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, v, nil, "missing value")
+	}
+	testutil.CheckEqual(t, atomic.LoadInt32(&calls), int32(1), "loader calls for negative")
+	testutil.CheckEqual(t, rq.Get("missing"), nil, "Get of negative result")
+	clock.Advance(2 * time.Minute)
+	rq.GetOrLoad("missing", loader, time.Second)
+	testutil.CheckEqual(t, atomic.LoadInt32(&calls), int32(2), "loader calls after NegativeTTL")
+	<-loaded
+	<-loaded
+
+	// stale values are served while refreshed in the background
+	v, _ := rq.GetOrLoad("k", loader, time.Second)
+	<-loaded
+	testutil.CheckEqual(t, v, "v1", "loaded value")
+	result.Store("v2")
+	clock.Advance(2 * time.Second)
+	v, _ = rq.GetOrLoad("k", loader, time.Second)
+	testutil.CheckEqual(t, v, "v1", "stale value")
+	<-loaded
+	// wait for the background load to store its result
+	for i := 0; i < 100 && rq.Get("k") != "v2"; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	v, _ = rq.GetOrLoad("k", loader, time.Second)
+	testutil.CheckEqual(t, v, "v2", "refreshed value")
+	testutil.CheckEqual(t, atomic.LoadInt32(&calls), int32(4), "loader calls after refresh")
+}
+
+func TestGetOrLoadSuperseded(t *testing.T) {
+	clock := &testClock{t: time.Unix(1000, 0)}
+	loadErrs := make(chan error, 1)
+	rq := NewWithOptions(Options{
+		UseLock:     true,
+		Now:         clock.Now,
+		StaleTTL:    time.Minute,
+		OnLoadError: func(key interface{}, err error) { loadErrs <- err },
+	})
+	defer rq.Close()
+	started, release := make(chan struct{}), make(chan struct{})
+	loader := func(key interface{}) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		return "loaded", nil
+	}
+
+	// a Put while loading wins over the loaded value
+	done := make(chan interface{})
+	go func() {
+		v, _ := rq.GetOrLoad("k", loader, time.Second)
+		done <- v
+	}()
+	<-started
+	rq.Put("k", "put", 0)
+	release <- struct{}{}
+	testutil.CheckEqual(t, <-done, "loaded", "returned value")
+	testutil.CheckEqual(t, rq.Get("k"), "put", "stored value after Put")
+
+	// so does a Remove
+	go func() {
+		v, _ := rq.GetOrLoad("r", loader, time.Second)
+		done <- v
+	}()
+	<-started
+	rq.Removes("r")
+	release <- struct{}{}
+	<-done
+	testutil.CheckEqual(t, rq.Get("r"), nil, "stored value after Remove")
+
+	// errors of background refreshes are reported
+	errLoad := errors.New("refresh failed")
+	rq.GetOrLoad("s", func(key interface{}) (interface{}, error) { return "v1", nil }, time.Second)
+	clock.Advance(2 * time.Second)
+	v, err := rq.GetOrLoad("s", func(key interface{}) (interface{}, error) { return nil, errLoad }, time.Second)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, v, "v1", "stale value")
+	testutil.CheckEqual(t, <-loadErrs, errLoad, "refresh error")
+}
+
+func TestGetOrLoadStaleWithoutLock(t *testing.T) {
+	clock := &testClock{t: time.Unix(1000, 0)}
+	rq := NewWithOptions(Options{Now: clock.Now, StaleTTL: time.Minute})
+	defer rq.Close()
+	var n int
+	loader := func(key interface{}) (interface{}, error) {
+		n++
+		return n, nil
+	}
+	v, err := rq.GetOrLoad("k", loader, time.Second)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, v, 1, "loaded")
+	clock.Advance(2 * time.Second)
+	// the stale value is refreshed synchronously, so the store is never written concurrently
+	v, err = rq.GetOrLoad("k", loader, time.Second)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, v, 2, "refreshed")
+	rq.Put("other", 0, 0)
+	testutil.CheckEqual(t, rq.Get("k"), 2, "stored")
+}
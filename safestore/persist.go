@@ -0,0 +1,117 @@
+package safestore
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ugorji/go-common/errorutil"
+)
+
+// Encoder is implemented by encoders which can write a stream of values
+// e.g. *gob.Encoder, *json.Encoder, *codec.Encoder, etc.
+type Encoder interface {
+	Encode(v interface{}) error
+}
+
+// Decoder is implemented by decoders which can read a stream of values
+// e.g. *gob.Decoder, *json.Decoder, *codec.Decoder, etc.
+//
+// Decode must return io.EOF when there are no more values in the stream.
+type Decoder interface {
+	Decode(v interface{}) error
+}
+
+// Persister is implemented by stores which can be snapshot and restored (e.g. T and Sharded).
+type Persister interface {
+	// Snapshot writes all the entries in the store to the Encoder.
+	Snapshot(enc Encoder) error
+	// Restore reads entries from the Decoder into the store,
+	// skipping entries which have expired, and returns how many were restored.
+	Restore(dec Decoder) (n int, err error)
+}
+
+// SnapshotItem is a single entry written during a Snapshot.
+//
+// If using an encoding like gob, ensure that the types of the keys and values
+// are registered (via gob.Register).
+type SnapshotItem struct {
+	Key   interface{}
+	Value interface{}
+	// Expiry is the time (in unix nanoseconds) when this entry expires.
+	// It is 0 if the entry does not expire.
+	Expiry int64
+}
+
+// SaveFile snapshots the store into a file.
+//
+// The snapshot is written to a temporary file first, and then renamed,
+// so an existing snapshot is not lost if there is an error.
+func SaveFile(p Persister, fpath string, newEnc func(io.Writer) Encoder) (err error) {
+	defer errorutil.OnError(&err)
+	f, err := os.CreateTemp(filepath.Dir(fpath), filepath.Base(fpath)+".tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	w := bufio.NewWriter(f)
+	if err = p.Snapshot(newEnc(w)); err != nil {
+		return
+	}
+	if err = w.Flush(); err != nil {
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	return os.Rename(f.Name(), fpath)
+}
+
+// LoadFile restores the store from a file previously written by SaveFile.
+func LoadFile(p Persister, fpath string, newDec func(io.Reader) Decoder) (n int, err error) {
+	defer errorutil.OnError(&err)
+	f, err := os.Open(fpath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return p.Restore(newDec(bufio.NewReader(f)))
+}
+
+func snapshotTo(enc Encoder, items []SnapshotItem) (err error) {
+	for i := range items {
+		if err = enc.Encode(&items[i]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// restoreFrom decodes SnapshotItems and calls fn with the remaining ttl
+// for each one which has not yet expired.
+func restoreFrom(dec Decoder, nowNs int64, fn func(key, value interface{}, ttl time.Duration)) (n int, err error) {
+	for {
+		var si SnapshotItem
+		if err = dec.Decode(&si); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		var ttl time.Duration
+		if si.Expiry != 0 {
+			if ttl = time.Duration(si.Expiry - nowNs); ttl <= 0 {
+				continue
+			}
+		}
+		fn(si.Key, si.Value, ttl)
+		n++
+	}
+}
@@ -0,0 +1,34 @@
+package safestore
+
+import (
+	"encoding/gob"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func TestShardedSnapshotRestore(t *testing.T) {
+	s := NewSharded(4, nil)
+	s.Put("a", "va", 0)
+	s.Put("b", "vb", time.Hour)
+	s.Put("c", "vc", 10*time.Millisecond)
+	s.Puts(&Item{"d", "vd", 0}, &Item{"e", "ve", 0})
+	s.Removes("e")
+	testutil.CheckEqual(t, len(s.GetAll()), 4, "number of entries")
+
+	fpath := filepath.Join(t.TempDir(), "safestore.snapshot")
+	err := SaveFile(s, fpath, func(w io.Writer) Encoder { return gob.NewEncoder(w) })
+	testutil.CheckErr(t, err)
+
+	time.Sleep(20 * time.Millisecond) // let "c" expire while we are "down"
+
+	s2 := NewSharded(3, nil)
+	n, err := LoadFile(s2, fpath, func(r io.Reader) Decoder { return gob.NewDecoder(r) })
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, n, 3, "number of restored entries")
+	testutil.CheckEqual(t, s2.Gets("a", "b", "c", "d", "e"),
+		[]interface{}{"va", "vb", nil, "vd", nil}, "restored values")
+}
@@ -0,0 +1,220 @@
+package safestore
+
+import (
+	"container/heap"
+	"container/list"
+	"hash/maphash"
+	"math/bits"
+)
+
+// Policy decides which entries to evict when a bounded T is full.
+//
+// A Policy tracks keys only. All its methods are called while the T is locked,
+// so an implementation does not need its own synchronization.
+type Policy interface {
+	// Added is called when a new key is added to the store.
+	Added(key interface{})
+	// Accessed is called when an existing key is read or updated.
+	Accessed(key interface{})
+	// Removed is called when a key is removed from the store (deleted, expired or evicted).
+	Removed(key interface{})
+	// Victim returns the key which should be evicted next.
+	Victim() (key interface{}, ok bool)
+	// Admit is called when the store is full, before a new key is added.
+	// It returns false if the candidate should be rejected instead of evicting the victim.
+	Admit(candidate, victim interface{}) bool
+}
+
+// lru evicts the least recently used key.
+type lru struct {
+	l *list.List
+	m map[interface{}]*list.Element
+}
+
+// NewLRU returns a Policy which evicts the least recently used entry.
+func NewLRU() Policy {
+	return &lru{l: list.New(), m: make(map[interface{}]*list.Element)}
+}
+
+func (x *lru) Added(key interface{}) {
+	x.m[key] = x.l.PushFront(key)
+}
+
+func (x *lru) Accessed(key interface{}) {
+	if e := x.m[key]; e != nil {
+		x.l.MoveToFront(e)
+	}
+}
+
+func (x *lru) Removed(key interface{}) {
+	if e := x.m[key]; e != nil {
+		x.l.Remove(e)
+		delete(x.m, key)
+	}
+}
+
+func (x *lru) Victim() (key interface{}, ok bool) {
+	if e := x.l.Back(); e != nil {
+		return e.Value, true
+	}
+	return
+}
+
+func (x *lru) Admit(candidate, victim interface{}) bool { return true }
+
+// lfu evicts the least frequently used key.
+// Ties are broken by evicting the least recently used.
+type lfu struct {
+	h   lfuHeap
+	m   map[interface{}]*lfuEntry
+	seq uint64
+}
+
+type lfuEntry struct {
+	key   interface{}
+	freq  uint64
+	seq   uint64
+	index int
+}
+
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	return h[i].freq < h[j].freq || (h[i].freq == h[j].freq && h[i].seq < h[j].seq)
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// NewLFU returns a Policy which evicts the least frequently used entry.
+func NewLFU() Policy {
+	return &lfu{m: make(map[interface{}]*lfuEntry)}
+}
+
+func (x *lfu) Added(key interface{}) {
+	x.seq++
+	e := &lfuEntry{key: key, freq: 1, seq: x.seq}
+	x.m[key] = e
+	heap.Push(&x.h, e)
+}
+
+func (x *lfu) Accessed(key interface{}) {
+	if e := x.m[key]; e != nil {
+		x.seq++
+		e.freq++
+		e.seq = x.seq
+		heap.Fix(&x.h, e.index)
+	}
+}
+
+func (x *lfu) Removed(key interface{}) {
+	if e := x.m[key]; e != nil {
+		heap.Remove(&x.h, e.index)
+		delete(x.m, key)
+	}
+}
+
+func (x *lfu) Victim() (key interface{}, ok bool) {
+	if len(x.h) > 0 {
+		return x.h[0].key, true
+	}
+	return
+}
+
+func (x *lfu) Admit(candidate, victim interface{}) bool { return true }
+
+// tinyLFU wraps a Policy (which chooses the victim),
+// and only admits a new key if it has been seen more frequently than the victim.
+//
+// Frequencies are estimated using a count-min sketch, which is aged
+// (all counters halved) periodically so that it adapts to changes in popularity.
+type tinyLFU struct {
+	Policy
+	seed    maphash.Seed
+	rows    [tinyLFUDepth][]uint8
+	mask    uint64
+	adds    int
+	resetAt int
+}
+
+const tinyLFUDepth = 4
+
+// NewTinyLFU returns a Policy which uses p to choose victims, but only
+// admits a new entry if its estimated access frequency is higher than the victim's.
+//
+// This protects a cache from being flushed by a scan of rarely used keys.
+// size should be around the maximum number of entries in the store.
+func NewTinyLFU(p Policy, size int) Policy {
+	width := 16
+	for width < size {
+		width <<= 1
+	}
+	x := &tinyLFU{
+		Policy:  p,
+		seed:    maphash.MakeSeed(),
+		mask:    uint64(width - 1),
+		resetAt: width * 10,
+	}
+	for i := range x.rows {
+		x.rows[i] = make([]uint8, width)
+	}
+	return x
+}
+
+func (x *tinyLFU) Added(key interface{}) {
+	x.Policy.Added(key)
+	x.increment(key)
+}
+
+func (x *tinyLFU) Accessed(key interface{}) {
+	x.Policy.Accessed(key)
+	x.increment(key)
+}
+
+func (x *tinyLFU) Admit(candidate, victim interface{}) bool {
+	// record the attempt, so that keys which are repeatedly requested eventually get in.
+	x.increment(candidate)
+	return x.estimate(candidate) > x.estimate(victim) && x.Policy.Admit(candidate, victim)
+}
+
+func (x *tinyLFU) increment(key interface{}) {
+	h := maphash.Comparable(x.seed, key)
+	for i := range x.rows {
+		if c := &x.rows[i][bits.RotateLeft64(h, -16*i)&x.mask]; *c < 255 {
+			*c++
+		}
+	}
+	if x.adds++; x.adds >= x.resetAt {
+		x.adds = 0
+		for i := range x.rows {
+			for j := range x.rows[i] {
+				x.rows[i][j] >>= 1
+			}
+		}
+	}
+}
+
+func (x *tinyLFU) estimate(key interface{}) (v uint8) {
+	h := maphash.Comparable(x.seed, key)
+	v = 255
+	for i := range x.rows {
+		if c := x.rows[i][bits.RotateLeft64(h, -16*i)&x.mask]; c < v {
+			v = c
+		}
+	}
+	return
+}
@@ -0,0 +1,59 @@
+package safestore
+
+import (
+	"testing"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func TestBoundedPolicy(t *testing.T) {
+	var evicted []interface{}
+	onEvict := func(key, value interface{}) { evicted = append(evicted, key) }
+
+	// LRU: "a" is accessed, so "b" is the least recently used.
+	evicted = nil
+	rq := NewWithOptions(Options{UseLock: true, MaxEntries: 2, OnEvict: onEvict})
+	rq.Put("a", 1, 0)
+	rq.Put("b", 2, 0)
+	rq.Get("a")
+	rq.Put("c", 3, 0)
+	testutil.CheckEqual(t, evicted, []interface{}{"b"}, "lru evicted")
+	testutil.CheckEqual(t, rq.Gets("a", "b", "c"), []interface{}{1, nil, 3}, "lru values")
+
+	// LFU: "b" is accessed more, so "a" is the least frequently used.
+	evicted = nil
+	rq = NewWithOptions(Options{MaxEntries: 2, Policy: NewLFU(), OnEvict: onEvict})
+	rq.Put("a", 1, 0)
+	rq.Put("b", 2, 0)
+	rq.Get("b")
+	rq.Get("b")
+	rq.Get("a")
+	rq.Put("c", 3, 0)
+	testutil.CheckEqual(t, evicted, []interface{}{"a"}, "lfu evicted")
+	testutil.CheckEqual(t, rq.Len(), 2, "lfu len")
+
+	// TinyLFU: a key seen once is not admitted over a popular one.
+	evicted = nil
+	rq = NewWithOptions(Options{MaxEntries: 1, Policy: NewTinyLFU(NewLRU(), 1), OnEvict: onEvict})
+	rq.Put("a", 1, 0)
+	rq.Get("a")
+	rq.Get("a")
+	rq.Put("b", 2, 0)
+	testutil.CheckEqual(t, evicted, []interface{}{"b"}, "tinylfu rejected")
+	testutil.CheckEqual(t, rq.Gets("a", "b"), []interface{}{1, nil}, "tinylfu values")
+
+	// MaxBytes: each entry is 10 bytes, so only 2 fit.
+	evicted = nil
+	rq = NewWithOptions(Options{
+		MaxBytes: 25,
+		Sizer:    func(key, value interface{}) int { return 10 },
+		OnEvict:  onEvict,
+	})
+	rq.Put("a", 1, 0)
+	rq.Put("b", 2, 0)
+	rq.Put("c", 3, 0)
+	rq.Removes("b")
+	rq.Put("d", 4, 0)
+	testutil.CheckEqual(t, evicted, []interface{}{"a"}, "maxbytes evicted")
+	testutil.CheckEqual(t, rq.Gets("a", "b", "c", "d"), []interface{}{nil, nil, 3, 4}, "maxbytes values")
+}
@@ -1,8 +1,13 @@
 package safestore
 
 import (
+	"iter"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/ugorji/go-common/reflectutil"
 )
 
 var safestoreSeq uint64 = 0
@@ -24,39 +29,96 @@ Use it as a request-scoped cache, etc
 
 Sample Uses:
  - Long-lived shared Cache store
- - Short-lived Request-Scoped Attribute Store
+ - Short-lived Request-Scoped Attribute Store (see Scope, NewChildContext and Middleware)
  ...
 
 A T can be lock enabled or not. If you do not use any goroutines within
 your code, then create a lock-less T. Purging within a lock-less T
-is best effort (we don't synchronize anything).
+is only done during writes (we don't synchronize anything).
 
 We also support using safestore as a cache.
   - You put in items along with a timestamp and expiry time
   - A goroutine runs which will purge expired entries out of the cache
+    (call Close to stop it when done with the T)
+
+Items with a TTL are kept in a min-heap ordered by expiry time,
+so purging only touches the entries which have expired.
+Get will never return an expired value, even if it has not yet been purged.
 
-Note that, even when used as a cache with a TTL, Get will always return the actual
-value stored.
+A T can also be bounded, by a maximum number of entries and/or an approximate
+size in bytes (see Options). When full, entries are evicted based on a Policy (e.g. LRU, LFU).
+Since a Policy tracks accesses, reads on a bounded lock-enabled T take the write lock.
 
 */
 type T struct {
 	//id uint64        //added for debugging
-	container        map[interface{}]interface{}
-	lock             sync.RWMutex
-	useLock          bool
-	tickerIntervalNs int64
-	ticker           *time.Ticker
-	tickerC          <-chan time.Time
-	tickerResetC     chan bool
-	tickerOnce       sync.Once
-	lastPurgeNs      int64 // set to -1 after each purge.
-	tickerChanges    []int64
+	container map[interface{}]interface{}
+	lock      sync.RWMutex
+	useLock   bool
+
+	expiry    expiryHeap
+	wakeC     chan struct{} // wakes up the purge goroutine when the next expiry changes
+	closeC    chan struct{}
+	purgeOnce sync.Once
+	closeOnce sync.Once
+
+	loads  map[interface{}]*loadCall // in-flight loads (see GetOrLoad)
+	loadMu sync.Mutex
+	nloads atomic.Int32 // len(loads), so writes can skip loadMu when no load is in flight
+
+	pending []Event // events queued while locked, and dispatched once unlocked
+	subs    []*Subscription
+	nsubs   atomic.Int32
+	subMu   sync.Mutex
+
+	opts  Options
+	sizes map[interface{}]int // only tracked if opts.MaxBytes > 0
+	bytes int64
+}
+
+// Options configures a T created via NewWithOptions.
+type Options struct {
+	// UseLock says whether the T is safe for concurrent use.
+	UseLock bool
+	// MaxEntries is the maximum number of entries in the store (0 means no limit).
+	MaxEntries int
+	// MaxBytes is the maximum approximate size of all entries in the store (0 means no limit).
+	MaxBytes int64
+	// Sizer returns the approximate size of an entry, and is used if MaxBytes is set.
+	// If nil, the size is estimated using reflectutil.ApproxDataSize on the key and value.
+	Sizer func(key, value interface{}) int
+	// Policy decides which entries to evict when the store is full.
+	// If nil, NewLRU() is used if MaxEntries or MaxBytes is set.
+	Policy Policy
+	// OnEvict is called for each entry evicted to make room for another,
+	// or rejected by the Policy when the store is full.
+	//
+	// It is called after the lock is released, so it can safely call back into the store.
+	// See Subscribe for notifications of all changes.
+	OnEvict func(key, value interface{})
+	// Now returns the current time. If nil, time.Now is used.
+	//
+	// It can be injected to control expiry in tests.
+	Now func() time.Time
+	// NegativeTTL is how long GetOrLoad caches that a key was not found
+	// (i.e. the Loader returned a nil value and nil error). 0 means it is not cached.
+	NegativeTTL time.Duration
+	// StaleTTL is how long after its TTL that a value loaded by GetOrLoad can still
+	// be served (stale), while it is refreshed in the background. 0 means it is not served stale.
+	// Without UseLock, the refresh is synchronous instead (see GetOrLoad).
+	StaleTTL time.Duration
+	// OnLoadError is called with the error of a background refresh (see StaleTTL),
+	// which has no caller to return it to. The stale value is kept until it expires.
+	OnLoadError func(key interface{}, err error)
 }
 
 type safestoreItem struct {
+	key          interface{}
 	value        interface{}
 	loadTimeNs   int64
 	removeTimeNs int64
+	freshTimeNs  int64 // if non-zero, value is stale after this time (see Options.StaleTTL)
+	index        int   // index in the expiry heap
 }
 
 type Item struct {
@@ -71,20 +133,60 @@ func New(useLock bool) *T {
 		container: make(map[interface{}]interface{}),
 		useLock:   useLock,
 	}
+	if useLock {
+		a.wakeC = make(chan struct{}, 1) //must have buffer of 1 (so someone gets a message in)
+		a.closeC = make(chan struct{})
+	}
+	return a
+}
+
+// NewWithOptions creates a T configured by the Options (e.g. to bound its size).
+func NewWithOptions(opts Options) *T {
+	a := New(opts.UseLock)
+	if opts.MaxEntries > 0 || opts.MaxBytes > 0 {
+		if opts.Policy == nil {
+			opts.Policy = NewLRU()
+		}
+		if opts.MaxBytes > 0 {
+			if opts.Sizer == nil {
+				opts.Sizer = approxSize
+			}
+			a.sizes = make(map[interface{}]int)
+		}
+	}
+	a.opts = opts
 	return a
 }
 
-func (rq *T) purgeLoopFn() {
-	rq.tickerResetC = make(chan bool, 1) //must have buffer of 1 (so someone gets a message in)
-	go rq.purgeLoop()
+func approxSize(key, value interface{}) int {
+	return reflectutil.ApproxDataSize(reflect.ValueOf(key)) +
+		reflectutil.ApproxDataSize(reflect.ValueOf(value))
+}
+
+// rlock acquires the lock for reading.
+// A T with a Policy must record accesses, so it takes the write lock.
+func (rq *T) rlock() {
+	if rq.opts.Policy != nil {
+		rq.lock.Lock()
+	} else {
+		rq.lock.RLock()
+	}
+}
+
+func (rq *T) runlock() {
+	if rq.opts.Policy != nil {
+		rq.lock.Unlock()
+	} else {
+		rq.lock.RUnlock()
+	}
 }
 
 func (rq *T) Get(key interface{}) (v interface{}) {
 	if rq.useLock {
-		rq.lock.RLock()
-		defer rq.lock.RUnlock()
+		rq.rlock()
+		defer rq.runlock()
 	}
-	return rq.get(key)
+	return rq.getAccessed(key)
 }
 
 func (rq *T) Gets(key ...interface{}) (v []interface{}) {
@@ -92,27 +194,36 @@ func (rq *T) Gets(key ...interface{}) (v []interface{}) {
 		return
 	}
 	if rq.useLock {
-		rq.lock.RLock()
-		defer rq.lock.RUnlock()
+		rq.rlock()
+		defer rq.runlock()
 	}
 	v = make([]interface{}, len(key))
 	for i := 0; i < len(key); i++ {
 		// fmt.Printf(">>>%v, %T\n", key[i], key[i])
-		v[i] = rq.get(key[i])
+		v[i] = rq.getAccessed(key[i])
 	}
 	return
 }
 
+// Len returns the number of entries in the store.
+func (rq *T) Len() int {
+	if rq.useLock {
+		rq.lock.RLock()
+		defer rq.lock.RUnlock()
+	}
+	return len(rq.container)
+}
+
 func (rq *T) GetAll() (v [][2]interface{}) {
 	if rq.useLock {
 		rq.lock.RLock()
 		defer rq.lock.RUnlock()
 	}
-	v = make([][2]interface{}, len(rq.container))
-	i := 0
-	for k, _ := range rq.container {
-		v[i] = [2]interface{}{k, rq.get(k)}
-		i++
+	v = make([][2]interface{}, 0, len(rq.container))
+	for k := range rq.container {
+		if val := rq.get(k); val != nil {
+			v = append(v, [2]interface{}{k, val})
+		}
 	}
 	return
 }
@@ -126,60 +237,168 @@ func (rq *T) Gets2(items ...*Item) {
 }
 
 func (rq *T) get(key interface{}) (v interface{}) {
+	v, _, _ = rq.find(key)
+	return
+}
+
+// find returns the value stored for the key, and whether it was found
+// (which is true for a cached negative result, with a nil value).
+func (rq *T) find(key interface{}) (v interface{}, found, stale bool) {
 	if key == nil {
 		return
 	}
-	if val, ok := rq.container[key]; ok {
-		if tt, ok := val.(*safestoreItem); ok {
-			val = tt.value
+	val, found := rq.container[key]
+	if !found {
+		return
+	}
+	if tt, ok := val.(*safestoreItem); ok {
+		ts := rq.now().UnixNano()
+		if ts >= tt.removeTimeNs {
+			found = false
+			return
 		}
-		v = val
+		stale = tt.freshTimeNs != 0 && ts >= tt.freshTimeNs
+		if val = tt.value; val == loadNotFound {
+			val = nil
+		}
+	}
+	v = val
+	return
+}
+
+// getAccessed is get, but also records the access with the Policy.
+func (rq *T) getAccessed(key interface{}) (v interface{}) {
+	v = rq.get(key)
+	if v != nil && rq.opts.Policy != nil {
+		rq.opts.Policy.Accessed(key)
 	}
 	return
 }
 
+// put stores the value (evicting other entries to make room for it if bounded).
 func (rq *T) put(key interface{}, val interface{}, ttlNs int64) {
+	var removeTimeNs int64
+	if ttlNs > 0 {
+		removeTimeNs = rq.now().UnixNano() + ttlNs
+	}
+	rq.putItem(key, val, removeTimeNs, 0)
+}
+
+// putItem is put, but with the (absolute) times when the value should be removed,
+// and when it becomes stale (see Options.StaleTTL). A time of 0 means never.
+func (rq *T) putItem(key interface{}, val interface{}, removeTimeNs, freshTimeNs int64) {
+	rq.supersedeLoad(key)
 	if val == nil {
-		delete(rq.container, key)
-	} else {
-		if ttlNs > 0 {
-			ts := time.Now()
-			i := &safestoreItem{val, ts.UnixNano(), ts.UnixNano() + ttlNs}
-			rq.container[key] = i
-		} else {
-			rq.container[key] = val
+		rq.remove(key, CauseRemoved)
+		return
+	}
+	old, exists := rq.container[key]
+	if tt, ok := old.(*safestoreItem); ok {
+		old = tt.value
+	}
+	var size int
+	if rq.sizes != nil {
+		size = rq.opts.Sizer(key, val)
+	}
+	if rq.opts.Policy != nil {
+		if exists {
+			rq.opts.Policy.Accessed(key)
+		}
+		if !rq.makeRoom(key, size, !exists) {
+			// the new value is rejected (and the old one is dropped as it was replaced)
+			rq.emit(Event{Key: key, Old: val, Cause: CauseEvicted})
+			if exists {
+				rq.remove(key, CauseEvicted)
+			}
+			return
 		}
+		if !exists {
+			rq.opts.Policy.Added(key)
+		}
+	}
+	if rq.sizes != nil {
+		rq.bytes += int64(size - rq.sizes[key])
+		rq.sizes[key] = size
+	}
+	if tt, ok := rq.container[key].(*safestoreItem); ok {
+		rq.removeExpiry(tt)
+	}
+	if removeTimeNs > 0 {
+		tt := &safestoreItem{
+			key:          key,
+			value:        val,
+			loadTimeNs:   rq.now().UnixNano(),
+			removeTimeNs: removeTimeNs,
+			freshTimeNs:  freshTimeNs,
+		}
+		rq.container[key] = tt
+		rq.addExpiry(tt)
+	} else {
+		rq.container[key] = val
+	}
+	if exists {
+		rq.emit(Event{Key: key, Old: old, New: val, Cause: CauseReplaced})
+	} else {
+		rq.emit(Event{Key: key, New: val, Cause: CauseAdded})
 	}
 }
 
-func (rq *T) Put(key interface{}, val interface{}, ttl time.Duration) {
-	if rq.useLock {
-		rq.lock.Lock()
-		defer rq.lock.Unlock()
+// remove deletes the key from the store, and returns the value which was stored.
+func (rq *T) remove(key interface{}, cause Cause) (v interface{}) {
+	v, ok := rq.container[key]
+	if !ok {
+		return
+	}
+	if tt, ok := v.(*safestoreItem); ok {
+		rq.removeExpiry(tt)
+		v = tt.value
+	}
+	delete(rq.container, key)
+	if rq.opts.Policy != nil {
+		rq.opts.Policy.Removed(key)
+	}
+	if rq.sizes != nil {
+		rq.bytes -= int64(rq.sizes[key])
+		delete(rq.sizes, key)
+	}
+	rq.emit(Event{Key: key, Old: v, Cause: cause})
+	return
+}
+
+// makeRoom evicts other entries until an entry of the given size can be stored for the key.
+//
+// Only new keys are subject to the Policy's admission check.
+func (rq *T) makeRoom(key interface{}, size int, isNew bool) (admitted bool) {
+	if rq.opts.MaxBytes > 0 && int64(size) > rq.opts.MaxBytes {
+		return
 	}
-	ttlNs := int64(ttl)
-	rq.puts(&Item{key, val, ttl})
-	if val != nil && ttlNs > 0 && (rq.tickerIntervalNs == 0 || ttlNs < rq.tickerIntervalNs) {
-		rq.resetTicker(ttlNs)
+	for i := 0; (isNew && rq.opts.MaxEntries > 0 && len(rq.container) >= rq.opts.MaxEntries) ||
+		(rq.opts.MaxBytes > 0 && rq.bytes-int64(rq.sizes[key])+int64(size) > rq.opts.MaxBytes); i++ {
+		victim, ok := rq.opts.Policy.Victim()
+		if !ok || victim == key {
+			return
+		}
+		if i == 0 && isNew && !rq.opts.Policy.Admit(key, victim) {
+			return
+		}
+		rq.remove(victim, CauseEvicted)
 	}
-	rq.noLockPurge()
+	return true
+}
+
+func (rq *T) Put(key interface{}, val interface{}, ttl time.Duration) {
+	rq.Puts(&Item{key, val, ttl})
 }
 
 func (rq *T) puts(items ...*Item) {
 	if len(items) == 0 {
 		return
 	}
-	var minTTLNs int64
+	// purge first, so expired entries do not cause live ones to be evicted
+	rq.purge(rq.now().UnixNano())
 	for i := 0; i < len(items); i++ {
 		rq.put(items[i].Key, items[i].Value, int64(items[i].TTL))
-		if t := int64(items[i].TTL); t > 0 && (minTTLNs == 0 || t < minTTLNs) {
-			minTTLNs = t
-		}
-	}
-	if minTTLNs > 0 && (rq.tickerIntervalNs == 0 || minTTLNs < rq.tickerIntervalNs) {
-		rq.resetTicker(minTTLNs)
 	}
-	rq.noLockPurge()
 }
 
 func (rq *T) Removes(key ...interface{}) {
@@ -191,102 +410,135 @@ func (rq *T) Removes(key ...interface{}) {
 }
 
 func (rq *T) Puts(items ...*Item) {
+	rq.dispatch(rq.lockedPuts(items))
+}
+
+func (rq *T) lockedPuts(items []*Item) (events []Event) {
 	if rq.useLock {
 		rq.lock.Lock()
 		defer rq.lock.Unlock()
 	}
 	rq.puts(items...)
+	return rq.takeEvents()
 }
 
 func (rq *T) Incr(key interface{}, delta int64, initVal uint64) (newval uint64) {
+	var events []Event
+	newval, events = rq.incr(key, delta, initVal)
+	rq.dispatch(events)
+	return
+}
+
+func (rq *T) incr(key interface{}, delta int64, initVal uint64) (newval uint64, events []Event) {
 	if rq.useLock {
 		rq.lock.Lock()
 		defer rq.lock.Unlock()
 	}
-	if v, ok := rq.get(key).(*uint64); ok {
-		newval = uint64(int64(*v) + delta)
+	if v, ok := rq.getAccessed(key).(*uint64); ok {
+		// the value is updated in place, so the event gets a copy of the old one
+		rq.supersedeLoad(key)
+		old := *v
+		newval = uint64(int64(old) + delta)
 		*v = newval
+		rq.emit(Event{Key: key, Old: &old, New: v, Cause: CauseReplaced})
 	} else {
 		newval = uint64(int64(initVal) + delta)
-		rq.put(key, &newval, 0)
+		nv := &newval
+		rq.put(key, nv, 0)
+		if _, stored := rq.container[key]; !stored {
+			// the counter was rejected by the Policy: no event is emitted for it
+			events = rq.pending[:0]
+			for _, ev := range rq.pending {
+				if ev.Key != key || ev.Old != nv {
+					events = append(events, ev)
+				}
+			}
+			rq.pending = events
+		}
 	}
+	return newval, rq.takeEvents()
+}
+
+// Update atomically replaces the value for the key with the result of fn,
+// which is called with the current value (or nil if not found).
+// If fn returns nil, the key is removed.
+//
+// The entry keeps its expiry time (if it had a TTL).
+// fn is called while the T is locked, so it must not call back into the T.
+func (rq *T) Update(key interface{}, fn func(old interface{}) interface{}) (v interface{}) {
+	var events []Event
+	v, events = rq.update(key, func(old interface{}) (interface{}, bool) { return fn(old), true })
+	rq.dispatch(events)
 	return
 }
 
-func (rq *T) purge(ts int64, lock bool) {
-	if lock {
+// update is Update, but fn can return false to leave the entry unchanged.
+func (rq *T) update(key interface{}, fn func(old interface{}) (v interface{}, change bool),
+) (v interface{}, events []Event) {
+	if rq.useLock {
 		rq.lock.Lock()
 		defer rq.lock.Unlock()
 	}
-	for k, v := range rq.container {
-		switch tt := v.(type) {
-		case *safestoreItem:
-			if ts >= tt.removeTimeNs {
-				delete(rq.container, k)
-			}
-		}
+	rq.purge(rq.now().UnixNano())
+	var removeTimeNs, freshTimeNs int64
+	if tt, ok := rq.container[key].(*safestoreItem); ok {
+		removeTimeNs, freshTimeNs = tt.removeTimeNs, tt.freshTimeNs
 	}
+	old := rq.get(key)
+	v, change := fn(old)
+	if !change {
+		return old, rq.takeEvents()
+	}
+	rq.putItem(key, v, removeTimeNs, freshTimeNs)
+	return v, rq.takeEvents()
 }
 
-func (rq *T) resetTicker(ttlNs int64) {
-	if rq.useLock {
-		//rq.tickerOnce.Do(rq.purgeLoopFn) //TODO: Go 1.1 Method Values will work here
-		rq.tickerOnce.Do(func() { rq.purgeLoopFn() })
-		rq.tickerChanges = append(rq.tickerChanges, ttlNs)
-		//best effort. Do not hang up trying to send this.
-		//Only one person needs to send a notification.
-		select {
-		case rq.tickerResetC <- true:
-		default:
+// All returns an iterator over all the entries in the store.
+//
+// It iterates over a snapshot (see GetAll), so the loop body can safely call into the T.
+func (rq *T) All() iter.Seq2[interface{}, interface{}] {
+	return func(yield func(key, value interface{}) bool) {
+		for _, kv := range rq.GetAll() {
+			if !yield(kv[0], kv[1]) {
+				return
+			}
 		}
-	} else {
-		rq.tickerIntervalNs = ttlNs
 	}
 }
 
-func (rq *T) updateTicker() {
-	if !rq.useLock || len(rq.tickerChanges) == 0 {
-		return
-	}
-	rq.lock.Lock()
-	defer rq.lock.Unlock()
-	var ttlNs int64
-	for i := range rq.tickerChanges {
-		if rq.tickerChanges[i] > ttlNs {
-			ttlNs = rq.tickerChanges[i]
-		}
-	}
-	rq.tickerChanges = rq.tickerChanges[0:0]
-	if ttlNs > 0 && rq.tickerIntervalNs != ttlNs {
-		if rq.ticker != nil {
-			rq.ticker.Stop()
-		}
-		rq.ticker = time.NewTicker(time.Duration(ttlNs))
-		rq.tickerC = rq.ticker.C
-		rq.tickerIntervalNs = ttlNs
-	}
+// Snapshot writes all the entries in the store to the Encoder,
+// along with their expiry time (if a TTL was set).
+//
+// The entries are copied out under the lock, and encoded after the lock is released.
+func (rq *T) Snapshot(enc Encoder) (err error) {
+	return snapshotTo(enc, rq.snapshotItems(rq.now().UnixNano()))
 }
 
-func (rq *T) purgeLoop() {
-	for {
-		select {
-		case tt, ok := <-rq.tickerC:
-			if ok && !tt.IsZero() {
-				rq.purge(tt.UnixNano(), rq.useLock)
-			}
-		case <-rq.tickerResetC:
-			rq.updateTicker()
-		}
-	}
+// Restore reads entries (written by Snapshot) from the Decoder into the store.
+// Entries which expired in the meantime are skipped.
+func (rq *T) Restore(dec Decoder) (n int, err error) {
+	return restoreFrom(dec, rq.now().UnixNano(), func(key, value interface{}, ttl time.Duration) {
+		rq.Put(key, value, ttl)
+	})
 }
 
-func (rq *T) noLockPurge() {
+func (rq *T) snapshotItems(ts int64) (items []SnapshotItem) {
 	if rq.useLock {
-		return
+		rq.lock.RLock()
+		defer rq.lock.RUnlock()
 	}
-	if tnow := time.Now().UnixNano(); (tnow - rq.lastPurgeNs) > rq.tickerIntervalNs {
-		rq.purge(tnow, false)
+	items = make([]SnapshotItem, 0, len(rq.container))
+	for k, v := range rq.container {
+		si := SnapshotItem{Key: k, Value: v}
+		if tt, ok := v.(*safestoreItem); ok {
+			if ts >= tt.removeTimeNs || tt.value == loadNotFound {
+				continue
+			}
+			si.Value, si.Expiry = tt.value, tt.removeTimeNs
+		}
+		items = append(items, si)
 	}
+	return
 }
 
 func storedKeys(items []*Item) (ikeys []interface{}) {
@@ -297,3 +549,5 @@ func storedKeys(items []*Item) (ikeys []interface{}) {
 	// fmt.Printf(">>>>>> storedKeys: ikeys: %v\n", ikeys)
 	return
 }
+
+var _, _ = I((*T)(nil)), Persister((*T)(nil))
@@ -55,8 +55,9 @@ func TestSafeStore(t *testing.T) {
 
 func TestSafeStoreNil(t *testing.T) {
 	rq := New(false)
-	mcs := make([]interface{}, 0, 4)
-	rq.PutAll(mcs, nil, nil)
+	mcs := make([]*Item, 0, 4)
+	rq.Puts(mcs...)
+	rq.Gets()
 }
 
 func BenchmarkSafeStore(b *testing.B) {
@@ -95,7 +96,7 @@ topLoop:
 				time.Sleep(sleep2)
 			}
 			rq[l].Put(rT0, k, 0)
-			_ = rq[l].GetAll([]interface{}{rT0, rT1, s1, s2})
+			_ = rq[l].Gets(rT0, rT1, s1, s2)
 			return false
 		}
 		fn2 := func(sleep2 time.Duration) (exit bool) {
@@ -106,8 +107,8 @@ topLoop:
 			if sleep2 != 0 {
 				time.Sleep(sleep2)
 			}
-			rq[l].PutAll([]interface{}{rT0, rT1, s1, s2}, []interface{}{k, k2, rT0, rT1},
-				[]time.Duration{sleeptime * 20, sleeptime * 40, sleeptime * 40, sleeptime * 60} )
+			rq[l].Puts(&Item{rT0, k, sleeptime * 20}, &Item{rT1, k2, sleeptime * 40},
+				&Item{s1, rT0, sleeptime * 40}, &Item{s2, rT1, sleeptime * 60})
 			_ = rq[l].Get(rT1)
 			return false
 		}
@@ -0,0 +1,144 @@
+package safestore
+
+import (
+	"hash/maphash"
+	"time"
+)
+
+// Sharded is a store which spreads its keys across a number of lock-enabled T's (shards).
+//
+// Since each shard has its own lock, there is much less lock contention
+// when used as a long-lived shared cache by many goroutines.
+//
+// The keys must be comparable (same as for a map key).
+type Sharded struct {
+	shards []*T
+	seed   maphash.Seed
+}
+
+// NewSharded returns a Sharded store with numShards shards.
+//
+// newFn is called to create each shard. It must return a lock-enabled T.
+// If nil, each shard is created via New(true).
+//
+// Any bounds (see Options) apply per shard, so divide the total limits by numShards.
+func NewSharded(numShards int, newFn func() *T) *Sharded {
+	if numShards < 1 {
+		numShards = 1
+	}
+	if newFn == nil {
+		newFn = func() *T { return New(true) }
+	}
+	s := &Sharded{
+		shards: make([]*T, numShards),
+		seed:   maphash.MakeSeed(),
+	}
+	for i := range s.shards {
+		s.shards[i] = newFn()
+	}
+	return s
+}
+
+func (s *Sharded) shard(key interface{}) *T {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+	return s.shards[maphash.Comparable(s.seed, key)%uint64(len(s.shards))]
+}
+
+func (s *Sharded) Get(key interface{}) (v interface{}) {
+	if key == nil {
+		return
+	}
+	return s.shard(key).Get(key)
+}
+
+func (s *Sharded) Gets(keys ...interface{}) (v []interface{}) {
+	if len(keys) == 0 {
+		return
+	}
+	v = make([]interface{}, len(keys))
+	for i := range keys {
+		v[i] = s.Get(keys[i])
+	}
+	return
+}
+
+func (s *Sharded) GetAll() (v [][2]interface{}) {
+	for _, t := range s.shards {
+		v = append(v, t.GetAll()...)
+	}
+	return
+}
+
+func (s *Sharded) Put(key interface{}, val interface{}, ttl time.Duration) {
+	s.shard(key).Put(key, val, ttl)
+}
+
+// Puts will put the items into their respective shards.
+//
+// Note that this is not atomic across shards.
+func (s *Sharded) Puts(items ...*Item) {
+	if len(items) == 0 {
+		return
+	}
+	m := make(map[*T][]*Item, len(s.shards))
+	for _, item := range items {
+		t := s.shard(item.Key)
+		m[t] = append(m[t], item)
+	}
+	for t, items2 := range m {
+		t.Puts(items2...)
+	}
+}
+
+func (s *Sharded) Removes(keys ...interface{}) {
+	items := make([]*Item, len(keys))
+	for i, v := range keys {
+		items[i] = &Item{v, nil, 0}
+	}
+	s.Puts(items...)
+}
+
+func (s *Sharded) GetOrLoad(key interface{}, loader Loader, ttl time.Duration) (v interface{}, err error) {
+	return s.shard(key).GetOrLoad(key, loader, ttl)
+}
+
+func (s *Sharded) Incr(key interface{}, delta int64, initVal uint64) (newval uint64) {
+	return s.shard(key).Incr(key, delta, initVal)
+}
+
+// Snapshot writes the entries of each shard in turn.
+//
+// Each shard is consistent within itself, but the snapshot is not atomic across shards.
+func (s *Sharded) Snapshot(enc Encoder) (err error) {
+	for _, t := range s.shards {
+		if err = t.Snapshot(enc); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *Sharded) Restore(dec Decoder) (n int, err error) {
+	return restoreFrom(dec, time.Now().UnixNano(), func(key, value interface{}, ttl time.Duration) {
+		s.Put(key, value, ttl)
+	})
+}
+
+// Purge removes all the expired entries from each shard.
+func (s *Sharded) Purge() {
+	for _, t := range s.shards {
+		t.Purge()
+	}
+}
+
+// Close stops the purge goroutine of each shard.
+func (s *Sharded) Close() error {
+	for _, t := range s.shards {
+		t.Close()
+	}
+	return nil
+}
+
+var _, _ = I((*Sharded)(nil)), Persister((*Sharded)(nil))
@@ -0,0 +1,174 @@
+package safestore
+
+import (
+	"iter"
+	"time"
+)
+
+// Store is a type-safe view of a T, with keys of type K and values of type V.
+//
+// It has the same semantics as T (locking, TTL's, expiry, bounds, loading),
+// as it is a thin layer over one. Values are stored boxed in an interface{}, so
+// (as with T) storing a nil interface value removes the key.
+type Store[K comparable, V any] struct {
+	t *T
+}
+
+// StoreItem is a typed Item, for use with Store.Puts.
+type StoreItem[K comparable, V any] struct {
+	Key   K
+	Value V
+	TTL   time.Duration
+}
+
+// Number is the set of types which can be used as typed counters (see Incr).
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// NewStore creates a Store over a new T, configured by the Options.
+func NewStore[K comparable, V any](opts Options) *Store[K, V] {
+	return &Store[K, V]{NewWithOptions(opts)}
+}
+
+// StoreOf returns a typed view of an existing T.
+//
+// All values in the T for keys of type K must be of type V.
+func StoreOf[K comparable, V any](t *T) *Store[K, V] {
+	return &Store[K, V]{t}
+}
+
+// Untyped returns the underlying T.
+func (s *Store[K, V]) Untyped() *T {
+	return s.t
+}
+
+func (s *Store[K, V]) Put(key K, val V, ttl time.Duration) {
+	s.t.Put(key, val, ttl)
+}
+
+func (s *Store[K, V]) Puts(items ...StoreItem[K, V]) {
+	items2 := make([]*Item, len(items))
+	for i := range items {
+		items2[i] = &Item{items[i].Key, items[i].Value, items[i].TTL}
+	}
+	s.t.Puts(items2...)
+}
+
+// Get returns the value for the key, and whether it was found.
+func (s *Store[K, V]) Get(key K) (v V, ok bool) {
+	v, ok = s.t.Get(key).(V)
+	return
+}
+
+// Gets returns the values for the keys (or the zero value, if not found).
+func (s *Store[K, V]) Gets(keys ...K) (v []V) {
+	if len(keys) == 0 {
+		return
+	}
+	keys2 := make([]interface{}, len(keys))
+	for i := range keys {
+		keys2[i] = keys[i]
+	}
+	vals := s.t.Gets(keys2...)
+	v = make([]V, len(vals))
+	for i := range vals {
+		v[i], _ = vals[i].(V)
+	}
+	return
+}
+
+func (s *Store[K, V]) Removes(keys ...K) {
+	keys2 := make([]interface{}, len(keys))
+	for i := range keys {
+		keys2[i] = keys[i]
+	}
+	s.t.Removes(keys2...)
+}
+
+func (s *Store[K, V]) Len() int {
+	return s.t.Len()
+}
+
+// Update atomically replaces the value for the key with the result of fn,
+// which is called with the current value and whether it was found.
+// If fn returns false, the key is removed.
+//
+// See T.Update for more details.
+func (s *Store[K, V]) Update(key K, fn func(old V, found bool) (v V, keep bool)) (v V, ok bool) {
+	v2 := s.t.Update(key, func(old interface{}) interface{} {
+		oldv, found := old.(V)
+		v, keep := fn(oldv, found)
+		if !keep {
+			return nil
+		}
+		return v
+	})
+	v, ok = v2.(V)
+	return
+}
+
+// GetOrLoad is the typed version of T.GetOrLoad.
+//
+// The loader returns found=false if the key was not found (see Options.NegativeTTL).
+func (s *Store[K, V]) GetOrLoad(key K, loader func(key K) (v V, found bool, err error),
+	ttl time.Duration) (v V, ok bool, err error) {
+	v2, err := s.t.GetOrLoad(key, func(key interface{}) (interface{}, error) {
+		v, found, err := loader(key.(K))
+		if err != nil || !found {
+			return nil, err
+		}
+		return v, nil
+	}, ttl)
+	v, ok = v2.(V)
+	return
+}
+
+// All returns an iterator over all the entries in the Store.
+//
+// It iterates over a snapshot, so the loop body can safely call into the Store.
+func (s *Store[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(key K, value V) bool) {
+		for k, v := range s.t.All() {
+			k2, ok := k.(K)
+			if !ok {
+				continue
+			}
+			v2, _ := v.(V)
+			if !yield(k2, v2) {
+				return
+			}
+		}
+	}
+}
+
+func (s *Store[K, V]) Purge() {
+	s.t.Purge()
+}
+
+func (s *Store[K, V]) Close() error {
+	return s.t.Close()
+}
+
+// Incr atomically adds delta to the value for the key (treating a missing value as 0),
+// and returns the new value.
+func Incr[K comparable, V Number](s *Store[K, V], key K, delta V) (v V) {
+	v, _ = s.Update(key, func(old V, found bool) (V, bool) { return old + delta, true })
+	return
+}
+
+// CompareAndSwap atomically sets the value for the key to value,
+// only if its current value is expected. It returns whether the swap was done.
+//
+// A missing key never matches.
+func CompareAndSwap[K comparable, V comparable](s *Store[K, V], key K, expected, value V) (swapped bool) {
+	_, events := s.t.update(key, func(v interface{}) (interface{}, bool) {
+		v2, found := v.(V)
+		swapped = found && v2 == expected
+		return value, swapped
+	})
+	s.t.dispatch(events)
+	return
+}
@@ -0,0 +1,55 @@
+package safestore
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func TestStore(t *testing.T) {
+	s := NewStore[string, int](Options{UseLock: true})
+	defer s.Close()
+	s.Put("a", 1, 0)
+	s.Puts(StoreItem[string, int]{"b", 2, time.Hour}, StoreItem[string, int]{"c", 3, 0})
+	s.Removes("c")
+	v, ok := s.Get("a")
+	testutil.CheckEqual(t, v, 1, "Get a")
+	testutil.CheckEqual(t, ok, true, "Get a found")
+	_, ok = s.Get("c")
+	testutil.CheckEqual(t, ok, false, "Get c found")
+	testutil.CheckEqual(t, s.Gets("a", "b", "c"), []int{1, 2, 0}, "Gets")
+
+	var keys []string
+	for k := range s.All() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	testutil.CheckEqual(t, keys, []string{"a", "b"}, "All keys")
+
+	testutil.CheckEqual(t, CompareAndSwap(s, "a", 5, 6), false, "CompareAndSwap mismatch")
+	testutil.CheckEqual(t, CompareAndSwap(s, "a", 1, 6), true, "CompareAndSwap match")
+	testutil.CheckEqual(t, CompareAndSwap(s, "z", 0, 1), false, "CompareAndSwap missing")
+	v, _ = s.Get("a")
+	testutil.CheckEqual(t, v, 6, "value after CompareAndSwap")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				Incr(s, "n", 2)
+			}
+		}()
+	}
+	wg.Wait()
+	v, _ = s.Get("n")
+	testutil.CheckEqual(t, v, 2000, "value after Incr")
+
+	s.Update("b", func(old int, found bool) (int, bool) { return 0, false })
+	_, ok = s.Get("b")
+	testutil.CheckEqual(t, ok, false, "Get b after Update removed it")
+}
@@ -0,0 +1,277 @@
+package simpleblobstore
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backend is the storage used by a BlobDriver.
+//
+// Objects are identified by a relative path, using / as the separator
+// (e.g. 3f/a2/07/5ZZZZZZZZZZZZZZZZZZ).
+//
+// This allows the key scheme and sharding of the BlobDriver
+// to be reused over different stores (local disk, memory, etc).
+type Backend interface {
+	// PutTemp creates a new temporary object, whose name starts with prefix.
+	PutTemp(prefix string) (TempWriter, error)
+	// Commit moves the (closed) temporary object to its final path.
+	Commit(tempPath, path string) error
+	// Open opens the object at path for reading.
+	Open(path string) (File, error)
+	// Stat returns information about the object at path.
+	Stat(path string) (ObjectInfo, error)
+	// Delete removes the object at path.
+	Delete(path string) error
+	// List calls fn for each object whose path starts with prefix (including temporary objects).
+	// If fn returns an error, listing stops and that error is returned.
+	List(prefix string, fn func(ObjectInfo) error) error
+}
+
+// TempWriter writes a temporary object in a Backend.
+type TempWriter interface {
+	io.WriteCloser
+	// Name returns the path of the temporary object.
+	Name() string
+}
+
+// File is an open'ed object in a Backend.
+type File interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// ObjectInfo holds metadata about an object in a Backend.
+type ObjectInfo struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// LocalBackend is a Backend which stores objects as files under a directory.
+//
+// The directory can be on a shared drive (e.g. NFS mounted),
+// so multiple backends can write to it simultaneously.
+type LocalBackend struct {
+	Dir string
+}
+
+type localTempWriter struct {
+	*os.File
+	name string
+}
+
+func (x localTempWriter) Name() string { return x.name }
+
+func (l LocalBackend) fpath(p string) string {
+	return filepath.Join(l.Dir, filepath.FromSlash(p))
+}
+
+func (l LocalBackend) PutTemp(prefix string) (w TempWriter, err error) {
+	f, err := os.CreateTemp(l.Dir, prefix)
+	if err != nil {
+		return
+	}
+	w = localTempWriter{f, filepath.Base(f.Name())}
+	return
+}
+
+func (l LocalBackend) Commit(tempPath, p string) (err error) {
+	fpath := l.fpath(p)
+	if err = os.MkdirAll(filepath.Dir(fpath), 0777); err != nil {
+		return
+	}
+	return os.Rename(l.fpath(tempPath), fpath)
+}
+
+func (l LocalBackend) Open(p string) (File, error) {
+	return os.Open(l.fpath(p))
+}
+
+func (l LocalBackend) Stat(p string) (oi ObjectInfo, err error) {
+	fi, err := os.Stat(l.fpath(p))
+	if err != nil {
+		return
+	}
+	oi = ObjectInfo{Path: p, Size: fi.Size(), ModTime: fi.ModTime()}
+	return
+}
+
+func (l LocalBackend) Delete(p string) error {
+	return os.Remove(l.fpath(p))
+}
+
+func (l LocalBackend) List(prefix string, fn func(ObjectInfo) error) (err error) {
+	// only walk the deepest directory which contains the prefix
+	root := l.Dir
+	if i := strings.LastIndexByte(prefix, '/'); i >= 0 {
+		root = l.fpath(prefix[:i])
+	}
+	err = filepath.WalkDir(root, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && fpath == root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		rel, err := filepath.Rel(l.Dir, fpath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && !strings.HasPrefix(rel+"/", prefix) && !strings.HasPrefix(prefix, rel+"/") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(rel, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(ObjectInfo{Path: rel, Size: fi.Size(), ModTime: fi.ModTime()})
+	})
+	return
+}
+
+// MemBackend is a Backend which stores objects in memory.
+//
+// It is safe for concurrent use, and is mostly useful for tests.
+type MemBackend struct {
+	mu  sync.RWMutex
+	m   map[string]*memObject
+	seq uint64
+	Now func() time.Time // if nil, time.Now is used
+}
+
+type memObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+type memTempWriter struct {
+	bytes.Buffer
+	b    *MemBackend
+	name string
+}
+
+type memFile struct {
+	*bytes.Reader
+}
+
+func NewMemBackend() *MemBackend {
+	return &MemBackend{m: make(map[string]*memObject)}
+}
+
+func (x *MemBackend) now() time.Time {
+	if x.Now != nil {
+		return x.Now()
+	}
+	return time.Now()
+}
+
+func (x *memTempWriter) Name() string { return x.name }
+
+// Close makes the temporary object visible in the backend.
+func (x *memTempWriter) Close() error {
+	x.b.mu.Lock()
+	defer x.b.mu.Unlock()
+	x.b.m[x.name] = &memObject{x.Bytes(), x.b.now()}
+	return nil
+}
+
+func (memFile) Close() error { return nil }
+
+func (x *MemBackend) PutTemp(prefix string) (w TempWriter, err error) {
+	name := prefix + strconv.FormatUint(atomic.AddUint64(&x.seq, 1), 10)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.m[name] = &memObject{nil, x.now()}
+	w = &memTempWriter{b: x, name: name}
+	return
+}
+
+func (x *MemBackend) Commit(tempPath, p string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	o, ok := x.m[tempPath]
+	if !ok {
+		return &fs.PathError{Op: "commit", Path: tempPath, Err: fs.ErrNotExist}
+	}
+	delete(x.m, tempPath)
+	x.m[path.Clean(p)] = o
+	return nil
+}
+
+func (x *MemBackend) get(op, p string) (o *memObject, err error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if o = x.m[path.Clean(p)]; o == nil {
+		err = &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+	}
+	return
+}
+
+func (x *MemBackend) Open(p string) (f File, err error) {
+	o, err := x.get("open", p)
+	if err != nil {
+		return
+	}
+	return memFile{bytes.NewReader(o.data)}, nil
+}
+
+func (x *MemBackend) Stat(p string) (oi ObjectInfo, err error) {
+	o, err := x.get("stat", p)
+	if err != nil {
+		return
+	}
+	oi = ObjectInfo{Path: path.Clean(p), Size: int64(len(o.data)), ModTime: o.modTime}
+	return
+}
+
+func (x *MemBackend) Delete(p string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	p = path.Clean(p)
+	if _, ok := x.m[p]; !ok {
+		return &fs.PathError{Op: "delete", Path: p, Err: fs.ErrNotExist}
+	}
+	delete(x.m, p)
+	return nil
+}
+
+// List calls fn for each matching object, in sorted order.
+//
+// fn is called without holding any lock, so it can call back into the MemBackend.
+func (x *MemBackend) List(prefix string, fn func(ObjectInfo) error) (err error) {
+	var ois []ObjectInfo
+	x.mu.RLock()
+	for k, o := range x.m {
+		if strings.HasPrefix(k, prefix) {
+			ois = append(ois, ObjectInfo{Path: k, Size: int64(len(o.data)), ModTime: o.modTime})
+		}
+	}
+	x.mu.RUnlock()
+	sort.Slice(ois, func(i, j int) bool { return ois[i].Path < ois[j].Path })
+	for _, oi := range ois {
+		if err = fn(oi); err != nil {
+			return
+		}
+	}
+	return
+}
+
+var _, _ = Backend(LocalBackend{}), Backend((*MemBackend)(nil))
@@ -0,0 +1,85 @@
+package simpleblobstore
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func testBlobBackends(t *testing.T) map[string]Backend {
+	return map[string]Backend{
+		"local": LocalBackend{t.TempDir()},
+		"mem":   NewMemBackend(),
+	}
+}
+
+func TestBackend(t *testing.T) {
+	for name, b := range testBlobBackends(t) {
+		w, err := b.PutTemp(blobTempPrefix)
+		testutil.CheckErr(t, err)
+		_, err = io.WriteString(w, "hello world")
+		testutil.CheckErr(t, err)
+		testutil.CheckErr(t, w.Close())
+		testutil.CheckErr(t, b.Commit(w.Name(), "aa/bb/cc/blob1"))
+
+		oi, err := b.Stat("aa/bb/cc/blob1")
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, oi.Size, int64(11), name+": size")
+
+		f, err := b.Open("aa/bb/cc/blob1")
+		testutil.CheckErr(t, err)
+		f.Seek(6, io.SeekStart)
+		bs, err := io.ReadAll(f)
+		testutil.CheckErr(t, err)
+		f.Close()
+		testutil.CheckEqual(t, string(bs), "world", name+": content")
+
+		var paths []string
+		err = b.List("aa/b", func(oi ObjectInfo) error {
+			paths = append(paths, oi.Path)
+			return nil
+		})
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, paths, []string{"aa/bb/cc/blob1"}, name+": list")
+
+		testutil.CheckErr(t, b.Delete("aa/bb/cc/blob1"))
+		if _, err = b.Stat("aa/bb/cc/blob1"); err == nil {
+			testutil.Log(t, "%s: expecting error on Stat after Delete", name)
+			testutil.Fail(t)
+		}
+	}
+}
+
+func TestBlobDriverBackend(t *testing.T) {
+	for name, b := range testBlobBackends(t) {
+		l := BlobDriver{Backend: b}
+		w, err := l.BlobWriter(context.Background(), "text/plain")
+		testutil.CheckErr(t, err)
+		_, err = io.WriteString(w, "hello blob")
+		testutil.CheckErr(t, err)
+		key, err := w.Finish()
+		testutil.CheckErr(t, err)
+
+		r, err := l.BlobReader(context.Background(), key)
+		testutil.CheckErr(t, err)
+		bs, err := io.ReadAll(r)
+		testutil.CheckErr(t, err)
+		r.Close()
+		testutil.CheckEqual(t, string(bs), "hello blob", name+": content")
+
+		// the blob is stored in the sharded location, and the temp file is gone
+		var paths []string
+		b.List("", func(oi ObjectInfo) error {
+			paths = append(paths, oi.Path)
+			return nil
+		})
+		testutil.CheckEqual(t, paths, []string{blobKeyString(key).Path()}, name+": list")
+		if strings.Count(paths[0], "/") != 3 {
+			testutil.Log(t, "%s: expecting 3 levels of sharding: %s", name, paths[0])
+			testutil.Fail(t)
+		}
+	}
+}
@@ -1,23 +1,24 @@
 package simpleblobstore
 
 import (
+	"context"
 	crand "crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
-	"io/ioutil"
 	"math"
 	"math/big"
 	"math/rand"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"sync/atomic"
 	"time"
 
-	"github.com/ugorji/go-serverapp/app"
 	"github.com/ugorji/go-common/errorutil"
 )
 
@@ -25,11 +26,15 @@ var (
 	blobBO  = binary.BigEndian //must be big endian, since we move upwards
 	blobSeq uint64
 	random  = rand.New(rand.NewSource(1 << 31))
+
+	InvalidKeyErr = errorutil.String("simpleblobstore: invalid blob key")
 )
 
 // BlobDriver manages all blob handling on the backend.
 //
-// For now, blobdir and datadir must be on a shared drive (e.g. NFS mounted).
+// Blobs are stored in a Backend. If no Backend is set, a LocalBackend over Dir is used.
+//
+// For a LocalBackend, blobdir and datadir must be on a shared drive (e.g. NFS mounted).
 // All backends can write to them simultaneously.
 // There might be some issues, but it's best to keep it simple for now.
 //
@@ -43,7 +48,7 @@ var (
 //     all information about the blob (ie timestamp, content type, etc).
 //
 // We typically do not use the uint64 at this time. We don't have a clean
-// way of checking if the file is unique.
+// way of checking if the file is unique (but see Dedup below).
 //
 // The key is a base64 encoding of:
 //    shardL1(1) shardL2(1) shardL3(1) random(5)
@@ -71,14 +76,70 @@ var (
 // However, for the same reasons as above, we'd stop at about 1000
 // children per directory, giving us up to 16 billion entities to store
 // (with room to grow).
+//
+// If Dedup is set, blobs are content-addressed instead: the SHA-256 of the content
+// is computed while writing, and identical uploads share a single stored copy
+// (see dedup.go for the key format and reference counting).
+//
+// Large blobs can also be uploaded in chunks, over a resumable session (see upload.go).
+// A session expires after UploadTTL (default: 24 hours) without activity.
+//
+// If Encryption is set, blobs are encrypted at rest (see encrypt.go).
 type BlobDriver struct {
-	Dir string
+	Dir        string
+	Backend    Backend
+	Dedup      bool
+	UploadTTL  time.Duration
+	Encryption *Encryption
+}
+
+// BlobInfo holds the metadata for a blob, as encoded in its key.
+type BlobInfo struct {
+	Key          string
+	ContentType  string
+	CreationTime time.Time
+	Size         int64
+}
+
+// Writer writes a new blob. Finish must be called to store it and get its key.
+type Writer interface {
+	io.Writer
+	Finish() (key string, err error)
+}
+
+// Reader reads the content of a blob.
+type Reader interface {
+	io.ReadSeekCloser
+}
+
+// Driver is the API for managing blobs.
+//
+// BlobDriver implements it. Adapters to other frameworks (e.g. go-serverapp)
+// live in sub-packages, so this package has no dependency on them.
+type Driver interface {
+	BlobWriter(ctx context.Context, contentType string) (Writer, error)
+	BlobReader(ctx context.Context, key string) (Reader, error)
+	BlobInfo(ctx context.Context, key string) (*BlobInfo, error)
+	BlobServe(ctx context.Context, key string, response http.ResponseWriter, request *http.Request) error
 }
 
+var _ Driver = BlobDriver{}
+
 type nblobw struct {
-	dir string
-	bi  *app.BlobInfo
-	*os.File
+	b  Backend
+	bi *BlobInfo
+	w  TempWriter
+	n  int64
+	h  hash.Hash // set if deduplicating
+}
+
+const blobTempPrefix = "ndb-blobw-tmp"
+
+func (l BlobDriver) backend() Backend {
+	if l.Backend != nil {
+		return l.Backend
+	}
+	return LocalBackend{l.Dir}
 }
 
 type blobKeyString string
@@ -87,8 +148,18 @@ type blobKeyBytes []byte
 
 func (k blobKeyString) Bytes() (bs []byte, err error) {
 	x := string(k)
+	if sum, x2, ok := k.dedup(); ok {
+		if _, err = hex.DecodeString(sum); err != nil {
+			return
+		}
+		x = x2
+	}
+	if len(x) < 17 || x[16] != '-' {
+		err = InvalidKeyErr
+		return
+	}
 	bs = make([]byte, 8)
-	j, err := strconv.ParseUint(x[:16], 16, 8)
+	j, err := strconv.ParseUint(x[:16], 16, 64)
 	if err != nil {
 		return
 	}
@@ -98,7 +169,9 @@ func (k blobKeyString) Bytes() (bs []byte, err error) {
 	if err != nil {
 		return
 	}
-	bs = append(bs, kbs...)
+	if bs = append(bs, kbs...); len(bs) < 28 {
+		err = InvalidKeyErr
+	}
 	return
 }
 
@@ -109,6 +182,15 @@ func (k blobKeyString) Location(basedir string) (dir, file string) {
 	return
 }
 
+// Path returns the relative path (within a Backend) where the blob is stored.
+func (k blobKeyString) Path() string {
+	x := string(k)
+	if sum, _, ok := k.dedup(); ok {
+		x = sum
+	}
+	return x[0:2] + "/" + x[2:4] + "/" + x[4:6] + "/" + x[6:]
+}
+
 func (x blobKeyBytes) String() (s string) {
 	bs := make([]byte, 17)
 	for i := 0; i < 16; i += 2 {
@@ -126,7 +208,7 @@ func (x blobKeyBytes) String() (s string) {
 	return string(bs)
 }
 
-func (bs blobKeyBytes) LoadBlobInfo(bi *app.BlobInfo) {
+func (bs blobKeyBytes) LoadBlobInfo(bi *BlobInfo) {
 	bi.Size = int64(blobBO.Uint64(bs[8:]))
 	bi.CreationTime = time.Unix(
 		int64(blobBO.Uint64(bs[16:])),
@@ -138,88 +220,134 @@ func (bs blobKeyBytes) BlobId() uint64 {
 	return blobBO.Uint64(bs[0:8])
 }
 
-func (w nblobw) Finish() (key string, err error) {
-	defer errorutil.OnError(&err)
-	if err = w.Close(); err != nil {
-		return
+func (w *nblobw) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.n += int64(n)
+	if w.h != nil {
+		w.h.Write(p[:n])
 	}
-	//fi, err := w.Stat()
-	fi, err := os.Stat(w.Name())
-	if err != nil {
+	return
+}
+
+func (w *nblobw) Finish() (key string, err error) {
+	defer errorutil.OnError(&err)
+	if err = w.w.Close(); err != nil {
 		return
 	}
-	w.bi.Size = fi.Size()
+	w.bi.Size = w.n
 
 	bs := blobInfoToKey(w.bi)
 	w.bi.Key = blobKeyBytes(bs).String()
-	//if err = os.Rename(w.Name(), filepath.Join(w.blobdir, w.bi.Key)); err != nil {return}
-	reldir, blobf := blobKeyString(w.bi.Key).Location(w.dir)
-	if err = os.MkdirAll(reldir, 0777); err != nil {
+	if w.h != nil {
+		if err = w.commitDedup(); err != nil {
+			return
+		}
+		key = w.bi.Key
 		return
 	}
-	if err = os.Rename(w.Name(), filepath.Join(reldir, blobf)); err != nil {
+	if err = w.b.Commit(w.w.Name(), blobKeyString(w.bi.Key).Path()); err != nil {
 		return
 	}
 	key = w.bi.Key
 	return
 }
 
-func (l BlobDriver) BlobWriter(ctx app.Context, contentType string,
-) (b app.BlobWriter, err error) {
+func (l BlobDriver) BlobWriter(ctx context.Context, contentType string,
+) (b Writer, err error) {
 	defer errorutil.OnError(&err)
-	bi := &app.BlobInfo{
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	bi := &BlobInfo{
 		ContentType:  contentType,
 		CreationTime: time.Now(),
 	}
 	//if bi.Filename, err = util.UUID(16); err != nil {
 	//	return
 	//}
-	tempf, err := ioutil.TempFile(l.Dir, "ndb-blobw-tmp")
+	b2 := l.backend()
+	tempw, err := l.putTemp(b2)
 	if err != nil {
 		return
 	}
-	b = &nblobw{l.Dir, bi, tempf}
+	w := &nblobw{b: b2, bi: bi, w: tempw}
+	if l.Dedup {
+		w.h = sha256.New()
+	}
+	b = w
 	return
 }
 
-func (l BlobDriver) BlobReader(ctx app.Context, key string) (br app.BlobReader, err error) {
+func (l BlobDriver) BlobReader(ctx context.Context, key string) (br Reader, err error) {
 	defer errorutil.OnError(&err)
-	reldir, blobf := blobKeyString(key).Location(l.Dir)
-	f, err := os.Open(filepath.Join(reldir, blobf))
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if _, err = blobKeyString(key).Bytes(); err != nil {
+		return
+	}
+	f, err := l.open(l.backend(), blobKeyString(key).Path())
 	if err != nil {
 		return
 	}
+	if _, _, ok := blobKeyString(key).dedup(); ok {
+		// the content may be shared, so check that this key still refers to it
+		if _, err = l.backend().Stat(blobKeyString(key).refPath()); err != nil {
+			f.Close()
+			return
+		}
+		br = &verifyReader{Reader: f, h: sha256.New(), sum: blobKeyString(key).sum(), verify: true}
+		return
+	}
 	br = f
 	return
 }
 
-func (l BlobDriver) BlobInfo(ctx app.Context, key string) (bi *app.BlobInfo, err error) {
+func (l BlobDriver) BlobInfo(ctx context.Context, key string) (bi *BlobInfo, err error) {
 	defer errorutil.OnError(&err)
 	bs, err := blobKeyString(key).Bytes()
 	if err != nil {
 		return
 	}
-	bi = new(app.BlobInfo)
+	bi = new(BlobInfo)
 	bi.Key = key
 	blobKeyBytes(bs).LoadBlobInfo(bi)
 	return
 }
 
-func (l BlobDriver) BlobServe(c app.Context, key string,
-	response http.ResponseWriter) (err error) {
+// BlobServe writes the blob to the response.
+//
+// The Content-Type, Content-Length, ETag and Last-Modified headers are set from
+// the metadata encoded in the key. If the request is non-nil, Range requests and
+// conditional requests (If-None-Match, If-Modified-Since, etc) are handled (see http.ServeContent).
+func (l BlobDriver) BlobServe(ctx context.Context, key string,
+	response http.ResponseWriter, request *http.Request) (err error) {
 	defer errorutil.OnError(&err)
-	f, err := os.Open(filepath.Join(l.Dir, key))
+	bi, err := l.BlobInfo(ctx, key)
+	if err != nil {
+		return
+	}
+	f, err := l.BlobReader(ctx, key)
 	if err != nil {
 		return
 	}
 	defer f.Close()
-	if _, err = io.Copy(response, f); err != nil {
+	h := response.Header()
+	if bi.ContentType != "" {
+		h.Set("Content-Type", bi.ContentType)
+	}
+	h.Set("ETag", `"`+key+`"`)
+	if request != nil {
+		http.ServeContent(response, request, "", bi.CreationTime, f)
 		return
 	}
+	h.Set("Content-Length", strconv.FormatInt(bi.Size, 10))
+	h.Set("Last-Modified", bi.CreationTime.UTC().Format(http.TimeFormat))
+	_, err = io.Copy(response, f)
 	return
 }
 
-func blobInfoToKey(bi *app.BlobInfo) (bs []byte) {
+func blobInfoToKey(bi *BlobInfo) (bs []byte) {
 	//random(8), size(8), creationTimeSec(8), creationTimeNs(4), contentType(n)
 	bs = make([]byte, 28+len(bi.ContentType))
 	blobBO.PutUint64(bs[0:], blobRand())
@@ -0,0 +1,50 @@
+package simpleblobstore
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func TestBlobInfo(t *testing.T) {
+	ctx := context.Background()
+	l := BlobDriver{Backend: NewMemBackend()}
+	t0 := time.Now()
+	w, err := l.BlobWriter(ctx, "image/png")
+	testutil.CheckErr(t, err)
+	_, err = io.WriteString(w, "not really a png")
+	testutil.CheckErr(t, err)
+	key, err := w.Finish()
+	testutil.CheckErr(t, err)
+
+	bi, err := l.BlobInfo(ctx, key)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, bi.Key, key, "key")
+	testutil.CheckEqual(t, bi.Size, int64(16), "size")
+	testutil.CheckEqual(t, bi.ContentType, "image/png", "content type")
+	if d := bi.CreationTime.Sub(t0); d < 0 || d > time.Minute {
+		testutil.Log(t, "unexpected creation time: %v (started at %v)", bi.CreationTime, t0)
+		testutil.Fail(t)
+	}
+
+	for _, k := range []string{"", "abc", "0123456789abcdef-", "0123456789abcdefX" + key[17:]} {
+		if _, err = l.BlobInfo(ctx, k); err == nil {
+			testutil.Log(t, "expecting error for invalid key: %q", k)
+			testutil.Fail(t)
+		}
+		if _, err = l.BlobReader(ctx, k); err == nil {
+			testutil.Log(t, "expecting error for invalid key: %q", k)
+			testutil.Fail(t)
+		}
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = l.BlobReader(cctx, key); err == nil {
+		testutil.Log(t, "expecting error for canceled context")
+		testutil.Fail(t)
+	}
+}
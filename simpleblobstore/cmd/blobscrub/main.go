@@ -0,0 +1,116 @@
+/*
+Command blobscrub checks the integrity of a simpleblobstore directory.
+
+It walks the shard tree, reports corrupt, orphaned and misplaced files
+as JSON on stdout, and optionally quarantines or repairs them.
+
+Usage:
+
+	blobscrub -dir BLOBDIR [-verify] [-quarantine] [-repair] [-min-age 1h] [-kek-file FILE] [-allow-plaintext]
+
+Key-encryption keys (for encrypted blobs) are read from the file named by -kek-file,
+else from the BLOBSCRUB_KEKS environment variable, so they never appear in the
+process arguments. Each key is id:hexkey, one per line (or separated by spaces
+in the environment variable); the first is the current one.
+Empty lines and lines starting with # are ignored.
+Without keys, encrypted blobs are reported as skipped, and never quarantined.
+
+It exits with status 1 if any problem (other than a skipped blob) was found, and 2 on error.
+*/
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/ugorji/go-common/simpleblobstore"
+)
+
+func main() {
+	var opts simpleblobstore.ScrubOptions
+	dir := flag.String("dir", "", "blob directory")
+	flag.BoolVar(&opts.Verify, "verify", false, "read and verify the content of every blob")
+	flag.BoolVar(&opts.Quarantine, "quarantine", false, "move corrupt and orphaned files to the quarantine directory")
+	flag.BoolVar(&opts.Repair, "repair", false, "move misplaced files to their correct path")
+	flag.DurationVar(&opts.MinAge, "min-age", 0, "skip files modified more recently than this")
+	kekFile := flag.String("kek-file", "", "file of key-encryption keys (id:hexkey per line), for encrypted blobs")
+	allowPlain := flag.Bool("allow-plaintext", false, "with keys, accept blobs which are not encrypted")
+	flag.Parse()
+	if *dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	keks, err := readKEKs(*kekFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "blobscrub: %v\n", err)
+		os.Exit(2)
+	}
+	found, err := run(*dir, keks, *allowPlain, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "blobscrub: %v\n", err)
+		os.Exit(2)
+	}
+	if found {
+		os.Exit(1)
+	}
+}
+
+// readKEKs returns the keys in the file, else in the BLOBSCRUB_KEKS environment variable.
+func readKEKs(file string) (keks []string, err error) {
+	s := os.Getenv("BLOBSCRUB_KEKS")
+	if file != "" {
+		var bs []byte
+		if bs, err = os.ReadFile(file); err != nil {
+			return
+		}
+		s = string(bs)
+	}
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			keks = append(keks, strings.Fields(line)...)
+		}
+	}
+	return
+}
+
+func run(dir string, keks []string, allowPlain bool, opts simpleblobstore.ScrubOptions) (found bool, err error) {
+	l := simpleblobstore.BlobDriver{Dir: dir}
+	for _, s := range keks {
+		id, hexkey, ok := strings.Cut(s, ":")
+		if !ok {
+			return false, errors.New("malformed key-encryption key: expecting id:hexkey")
+		}
+		var key []byte
+		if key, err = hex.DecodeString(hexkey); err != nil {
+			return
+		}
+		var k simpleblobstore.KEK
+		if k, err = simpleblobstore.NewAESKEK(id, key); err != nil {
+			return
+		}
+		if l.Encryption == nil {
+			l.Encryption = &simpleblobstore.Encryption{Current: k, AllowPlaintext: allowPlain}
+		} else {
+			l.Encryption.Previous = append(l.Encryption.Previous, k)
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	r, err := l.Scrub(ctx, opts)
+	if err != nil {
+		return
+	}
+	for _, f := range r.Findings {
+		if f.Problem != simpleblobstore.ScrubSkipped {
+			found = true
+		}
+	}
+	err = r.WriteJSON(os.Stdout)
+	return
+}
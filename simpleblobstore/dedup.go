@@ -0,0 +1,156 @@
+package simpleblobstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/ugorji/go-common/errorutil"
+)
+
+// Content-addressed (deduplicated) blobs.
+//
+// When BlobDriver.Dedup is set, the key of a blob is:
+//    hex(sha256(content)) "." key
+// where key is the regular key (described on BlobDriver) which holds the metadata.
+//
+// The content is stored under the hash, using the same 3-level shard layout:
+//   BLOBDIR/h0h1/h2h3/h4h5/h6...h63
+//
+// Every key which refers to that content has an (empty) reference object:
+//   BLOBDIR/h0h1/h2h3/h4h5/h6...h63.refs/key
+//
+// The number of reference objects is the reference count of the content.
+// References are objects (and not a counter), so backends writing to a shared
+// directory simultaneously do not need to coordinate.
+//
+// Adding a reference (and the content), and removing the last reference (and the content),
+// are serialized per content within a process (see lockContent), so a write never loses
+// its content to a concurrent Delete or GC. Processes sharing a directory do not coordinate.
+//
+// On read, the hash of the content is verified when the end is reached.
+
+var HashMismatchErr = errorutil.String("simpleblobstore: blob content does not match its hash")
+
+const blobRefsSuffix = ".refs/"
+
+// contentLocks serializes the reference counting of content-addressed blobs.
+// They are striped by hash, so unrelated content rarely contends.
+var contentLocks [64]sync.Mutex
+
+// lockContent locks the content stored at path p (i.e. its hash), returning the unlock func.
+func lockContent(p string) func() {
+	var h uint32
+	for i := 0; i < len(p); i++ {
+		h = h*31 + uint32(p[i])
+	}
+	mu := &contentLocks[h%uint32(len(contentLocks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// dedup splits a content-addressed key into its hex-encoded hash and regular key.
+func (k blobKeyString) dedup() (sum, key string, ok bool) {
+	x := string(k)
+	if len(x) > 65 && x[64] == '.' {
+		return x[:64], x[65:], true
+	}
+	return
+}
+
+func (k blobKeyString) sum() []byte {
+	sum, _, _ := k.dedup()
+	bs, _ := hex.DecodeString(sum)
+	return bs
+}
+
+// refPath returns the path of the reference object for a content-addressed key.
+func (k blobKeyString) refPath() string {
+	_, key, _ := k.dedup()
+	return k.Path() + blobRefsSuffix + key
+}
+
+func (w *nblobw) commitDedup() (err error) {
+	w.bi.Key = hex.EncodeToString(w.h.Sum(nil)) + "." + w.bi.Key
+	k := blobKeyString(w.bi.Key)
+	defer lockContent(k.Path())()
+	// add the reference before the content, so it is never seen as unreferenced
+	if err = putEmpty(w.b, k.refPath()); err != nil {
+		return
+	}
+	if _, err2 := w.b.Stat(k.Path()); err2 == nil {
+		// identical content is already stored
+		return w.b.Delete(w.w.Name())
+	}
+	return w.b.Commit(w.w.Name(), k.Path())
+}
+
+func putEmpty(b Backend, p string) (err error) {
+	w, err := b.PutTemp(blobTempPrefix)
+	if err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	return b.Commit(w.Name(), p)
+}
+
+// RefCount returns how many keys refer to the content of the blob with this key.
+//
+// It is 1 for a blob which is not content-addressed.
+func (l BlobDriver) RefCount(ctx context.Context, key string) (n int, err error) {
+	defer errorutil.OnError(&err)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if _, err = blobKeyString(key).Bytes(); err != nil {
+		return
+	}
+	k := blobKeyString(key)
+	b := l.backend()
+	if _, _, ok := k.dedup(); !ok {
+		if _, err = b.Stat(k.Path()); err == nil {
+			n = 1
+		}
+		return
+	}
+	err = b.List(k.Path()+blobRefsSuffix, func(ObjectInfo) error {
+		n++
+		return nil
+	})
+	return
+}
+
+// verifyReader checks the hash of the content once it is read through to the end.
+//
+// Only a read from the start can be verified, so verification stops
+// after a Seek anywhere but to the start.
+type verifyReader struct {
+	Reader
+	h      hash.Hash
+	sum    []byte
+	verify bool
+}
+
+func (r *verifyReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	if r.verify {
+		r.h.Write(p[:n])
+		if err == io.EOF && !bytes.Equal(r.h.Sum(nil), r.sum) {
+			err = HashMismatchErr
+		}
+	}
+	return
+}
+
+func (r *verifyReader) Seek(offset int64, whence int) (n int64, err error) {
+	if n, err = r.Reader.Seek(offset, whence); err == nil {
+		r.verify = n == 0
+		r.h.Reset()
+	}
+	return
+}
@@ -0,0 +1,121 @@
+package simpleblobstore
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func testPutBlob(t *testing.T, l BlobDriver, contentType, content string) (key string) {
+	w, err := l.BlobWriter(context.Background(), contentType)
+	testutil.CheckErr(t, err)
+	_, err = io.WriteString(w, content)
+	testutil.CheckErr(t, err)
+	key, err = w.Finish()
+	testutil.CheckErr(t, err)
+	return
+}
+
+func TestDedup(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBlobBackends(t) {
+		l := BlobDriver{Backend: b, Dedup: true}
+		k1 := testPutBlob(t, l, "text/plain", "same content")
+		k2 := testPutBlob(t, l, "text/html", "same content")
+		k3 := testPutBlob(t, l, "text/plain", "other content")
+		if k1 == k2 || blobKeyString(k1).Path() != blobKeyString(k2).Path() {
+			testutil.Log(t, "%s: expecting distinct keys sharing content: %s, %s", name, k1, k2)
+			testutil.Fail(t)
+		}
+		n, err := l.RefCount(ctx, k1)
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, n, 2, name+": refcount")
+		n, err = l.RefCount(ctx, k3)
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, n, 1, name+": refcount")
+
+		bi, err := l.BlobInfo(ctx, k2)
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, bi.ContentType, "text/html", name+": content type")
+		testutil.CheckEqual(t, bi.Size, int64(12), name+": size")
+
+		// only 2 copies of the content are stored (and no temp files remain)
+		var nblobs int
+		b.List("", func(oi ObjectInfo) error {
+			if oi.Size > 0 {
+				nblobs++
+			}
+			return nil
+		})
+		testutil.CheckEqual(t, nblobs, 2, name+": stored blobs")
+
+		r, err := l.BlobReader(ctx, k2)
+		testutil.CheckErr(t, err)
+		bs, err := io.ReadAll(r)
+		testutil.CheckErr(t, err)
+		r.Close()
+		testutil.CheckEqual(t, string(bs), "same content", name+": content")
+
+		// corrupt the stored content: reading through to the end must fail
+		tw, err := b.PutTemp(blobTempPrefix)
+		testutil.CheckErr(t, err)
+		io.WriteString(tw, "same c0ntent")
+		testutil.CheckErr(t, tw.Close())
+		testutil.CheckErr(t, b.Commit(tw.Name(), blobKeyString(k1).Path()))
+		r, err = l.BlobReader(ctx, k1)
+		testutil.CheckErr(t, err)
+		_, err = io.ReadAll(r)
+		r.Close()
+		testutil.CheckEqual(t, err, error(HashMismatchErr), name+": read error")
+	}
+}
+
+// slowDeleteBackend signals when it starts removing content, then delays it,
+// so a write of the same content can race with the Delete of its last reference.
+type slowDeleteBackend struct {
+	Backend
+	deleting chan struct{}
+}
+
+func (b slowDeleteBackend) Delete(p string) error {
+	if isContentPath(p) {
+		b.deleting <- struct{}{}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return b.Backend.Delete(p)
+}
+
+func TestDedupConcurrentPutDelete(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBlobBackends(t) {
+		sb := slowDeleteBackend{b, make(chan struct{})}
+		l := BlobDriver{Backend: sb, Dedup: true}
+		for i := 0; i < 4; i++ {
+			k0 := testPutBlob(t, l, "text/plain", "shared content")
+			errc := make(chan error, 1)
+			go func() { errc <- l.Delete(ctx, k0) }()
+			<-sb.deleting
+			// the content is being removed along with its last reference: a new write must keep it
+			k := testPutBlob(t, l, "text/plain", "shared content")
+			testutil.CheckErr(t, <-errc)
+			r, err := l.BlobReader(ctx, k)
+			testutil.CheckErr(t, err)
+			if err != nil {
+				continue
+			}
+			bs, err := io.ReadAll(r)
+			r.Close()
+			testutil.CheckErr(t, err)
+			testutil.CheckEqual(t, string(bs), "shared content", name+": content")
+			n, err := l.RefCount(ctx, k)
+			testutil.CheckErr(t, err)
+			testutil.CheckEqual(t, n, 1, name+": refcount")
+			go func() { errc <- l.Delete(ctx, k) }()
+			<-sb.deleting
+			testutil.CheckErr(t, <-errc)
+		}
+	}
+}
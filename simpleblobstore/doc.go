@@ -1,4 +1,7 @@
 /*
 Package simpleblobstore provides simple blob storage.
+
+It has no dependency on any server framework. Adapters to the blob interfaces of
+go-serverapp are in the sub-package simpleblobstore/serverapp.
 */
 package simpleblobstore
@@ -0,0 +1,428 @@
+package simpleblobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/ugorji/go-common/errorutil"
+)
+
+// Encryption at rest.
+//
+// Each blob is encrypted with its own random data key (AES-256-GCM).
+// The data key is wrapped (encrypted) by a key-encryption key (KEK),
+// and stored with the id of that KEK in a header at the start of the object:
+//    magic("SBE1") kidLen(1) kid(n) wrappedLen(2) wrapped(n)
+//
+// The content follows, in chunks of 64KB, each sealed separately:
+//    chunk(0) ... chunk(k) where chunk = ciphertext + tag(16)
+// The nonce of a chunk is its index, with a flag for the final chunk
+// (which is always present, possibly empty), so chunks cannot be reordered
+// and the content cannot be truncated without detection.
+// Since chunks are sealed separately, a Reader can seek without decrypting everything.
+//
+// Objects which do not start with the magic fail with DecryptErr, unless
+// Encryption.AllowPlaintext is set, when they are read as-is
+// (e.g. blobs written before encryption was enabled).
+//
+// With Dedup, the path of a blob is the SHA-256 of its plaintext, which is not encrypted:
+// anyone who can list the store can confirm whether it holds a known content.
+//
+// To rotate KEKs: set the new one as Encryption.Current, and move the old ones
+// to Encryption.Previous. Existing blobs are still readable, and Rewrap
+// re-wraps the data key of a blob with the current KEK (without re-encrypting its content).
+
+var (
+	DecryptErr    = errorutil.String("simpleblobstore: blob decryption failed")
+	UnknownKEKErr = errorutil.String("simpleblobstore: unknown key-encryption key")
+)
+
+const (
+	blobCryptMagic = "SBE1"
+	blobCryptChunk = 64 << 10
+	blobCryptTag   = 16
+)
+
+// KEK is a key-encryption key, which wraps the data keys of blobs.
+//
+// It can be backed by a key management service.
+type KEK interface {
+	// ID identifies the KEK. It is stored with each blob, and must be at most 255 bytes.
+	ID() string
+	Wrap(dataKey []byte) (wrapped []byte, err error)
+	Unwrap(wrapped []byte) (dataKey []byte, err error)
+}
+
+// Encryption configures the encryption at rest of blobs (see BlobDriver.Encryption).
+type Encryption struct {
+	Current  KEK   // wraps the data keys of new blobs
+	Previous []KEK // can still unwrap the data keys of existing blobs
+	// AllowPlaintext reads objects which are not encrypted as-is, instead of failing.
+	// Set it while migrating a store written before encryption was enabled.
+	AllowPlaintext bool
+}
+
+type aesKEK struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewAESKEK returns a KEK which wraps data keys locally using AES-GCM.
+//
+// The key must be 16, 24 or 32 bytes long.
+func NewAESKEK(id string, key []byte) (k KEK, err error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return
+	}
+	k = &aesKEK{id, aead}
+	return
+}
+
+func newAEAD(key []byte) (aead cipher.AEAD, err error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	return cipher.NewGCM(c)
+}
+
+func (k *aesKEK) ID() string { return k.id }
+
+func (k *aesKEK) Wrap(dataKey []byte) (wrapped []byte, err error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err = crand.Read(nonce); err != nil {
+		return
+	}
+	wrapped = k.aead.Seal(nonce, nonce, dataKey, []byte(k.id))
+	return
+}
+
+func (k *aesKEK) Unwrap(wrapped []byte) (dataKey []byte, err error) {
+	ns := k.aead.NonceSize()
+	if len(wrapped) < ns {
+		return nil, DecryptErr
+	}
+	if dataKey, err = k.aead.Open(nil, wrapped[:ns], wrapped[ns:], []byte(k.id)); err != nil {
+		err = DecryptErr
+	}
+	return
+}
+
+func (e *Encryption) kek(id string) (k KEK, err error) {
+	if e.Current != nil && e.Current.ID() == id {
+		return e.Current, nil
+	}
+	for _, k = range e.Previous {
+		if k.ID() == id {
+			return
+		}
+	}
+	return nil, UnknownKEKErr
+}
+
+// header returns the header for a blob encrypted with dataKey, wrapped by the current KEK.
+func (e *Encryption) header(dataKey []byte) (hdr []byte, err error) {
+	if e.Current == nil {
+		return nil, UnknownKEKErr
+	}
+	id := e.Current.ID()
+	wrapped, err := e.Current.Wrap(dataKey)
+	if err != nil {
+		return
+	}
+	if len(id) > 255 || len(wrapped) > 65535 {
+		return nil, errors.New("simpleblobstore: KEK id or wrapped key too long")
+	}
+	hdr = append([]byte(blobCryptMagic), byte(len(id)))
+	hdr = append(hdr, id...)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(wrapped)))
+	hdr = append(hdr, wrapped...)
+	return
+}
+
+// readHeader reads the header of an object.
+// If the object is not encrypted, it returns a nil dataKey if AllowPlaintext, else DecryptErr.
+func (e *Encryption) readHeader(r io.ReaderAt) (kid string, dataKey []byte, hdrLen int64, err error) {
+	sr := io.NewSectionReader(r, 0, 1<<20)
+	bs := make([]byte, len(blobCryptMagic)+1)
+	if _, err = io.ReadFull(sr, bs); err == io.EOF || err == io.ErrUnexpectedEOF ||
+		(err == nil && string(bs[:len(blobCryptMagic)]) != blobCryptMagic) {
+		// too short, or no magic: not encrypted
+		if err = nil; !e.AllowPlaintext {
+			err = DecryptErr
+		}
+		return
+	} else if err != nil {
+		return
+	}
+	kidb := make([]byte, int(bs[len(blobCryptMagic)])+2)
+	if _, err = io.ReadFull(sr, kidb); err != nil {
+		return "", nil, 0, DecryptErr
+	}
+	kid = string(kidb[:len(kidb)-2])
+	wrapped := make([]byte, binary.BigEndian.Uint16(kidb[len(kidb)-2:]))
+	if _, err = io.ReadFull(sr, wrapped); err != nil {
+		return "", nil, 0, DecryptErr
+	}
+	hdrLen, _ = sr.Seek(0, io.SeekCurrent)
+	k, err := e.kek(kid)
+	if err != nil {
+		return
+	}
+	dataKey, err = k.Unwrap(wrapped)
+	return
+}
+
+func blobCryptNonce(aead cipher.AEAD, idx int64, final bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce, uint64(idx))
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts the content written to a TempWriter.
+type encryptWriter struct {
+	TempWriter
+	aead cipher.AEAD
+	buf  []byte // plaintext of the current chunk
+	out  []byte
+	idx  int64
+}
+
+// putTemp creates a temp object, which is encrypted if Encryption is configured.
+func (l BlobDriver) putTemp(b Backend) (w TempWriter, err error) {
+	if w, err = b.PutTemp(blobTempPrefix); err != nil || l.Encryption == nil {
+		return
+	}
+	w0 := w
+	defer func() {
+		if err != nil {
+			w0.Close()
+			b.Delete(w0.Name())
+		}
+	}()
+	dataKey := make([]byte, 32)
+	if _, err = crand.Read(dataKey); err != nil {
+		return
+	}
+	hdr, err := l.Encryption.header(dataKey)
+	if err != nil {
+		return
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return
+	}
+	if _, err = w.Write(hdr); err != nil {
+		return
+	}
+	w = &encryptWriter{TempWriter: w0, aead: aead, buf: make([]byte, 0, blobCryptChunk)}
+	return
+}
+
+func (w *encryptWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if len(w.buf) == cap(w.buf) {
+			if err = w.flush(false); err != nil {
+				return
+			}
+		}
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return
+}
+
+func (w *encryptWriter) flush(final bool) (err error) {
+	w.out = w.aead.Seal(w.out[:0], blobCryptNonce(w.aead, w.idx, final), w.buf, nil)
+	if _, err = w.TempWriter.Write(w.out); err != nil {
+		return
+	}
+	w.idx++
+	w.buf = w.buf[:0]
+	return
+}
+
+// Close writes the final chunk, and closes the temp object.
+func (w *encryptWriter) Close() (err error) {
+	// the final chunk is always partial, so a full chunk is followed by an empty one
+	if len(w.buf) == cap(w.buf) {
+		err = w.flush(false)
+	}
+	if err == nil {
+		err = w.flush(true)
+	}
+	if err2 := w.TempWriter.Close(); err == nil {
+		err = err2
+	}
+	return
+}
+
+// decryptReader decrypts an object, a chunk at a time.
+type decryptReader struct {
+	f     File
+	aead  cipher.AEAD
+	off   int64 // start of the chunks (i.e. length of the header)
+	size  int64 // size of the plaintext
+	pos   int64
+	idx   int64 // index of the chunk decrypted in buf, or -1
+	buf   []byte
+	cbuf  []byte
+	final bool // the final chunk was authenticated
+}
+
+// open opens an object for reading, decrypting it if it is encrypted.
+func (l BlobDriver) open(b Backend, p string) (r Reader, err error) {
+	f, err := b.Open(p)
+	if err != nil || l.Encryption == nil {
+		return f, err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+		}
+	}()
+	_, dataKey, hdrLen, err := l.Encryption.readHeader(f)
+	if err != nil {
+		return
+	}
+	if dataKey == nil {
+		return f, nil
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return
+	}
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+	// the final chunk is always partial (maybe empty), so its size is the remainder
+	c := end - hdrLen
+	full, rem := c/(blobCryptChunk+blobCryptTag), c%(blobCryptChunk+blobCryptTag)
+	if rem < blobCryptTag {
+		err = DecryptErr
+		return
+	}
+	r = &decryptReader{f: f, aead: aead, off: hdrLen, idx: -1,
+		size: full*blobCryptChunk + rem - blobCryptTag}
+	return
+}
+
+func (r *decryptReader) load(idx int64) (err error) {
+	final := idx == r.size/blobCryptChunk
+	n := int64(blobCryptChunk)
+	if final {
+		n = r.size - idx*blobCryptChunk
+	}
+	r.cbuf = append(r.cbuf[:0], make([]byte, n+blobCryptTag)...)
+	if _, err = r.f.ReadAt(r.cbuf, r.off+idx*(blobCryptChunk+blobCryptTag)); err != nil && err != io.EOF {
+		return
+	}
+	if r.buf, err = r.aead.Open(r.buf[:0], blobCryptNonce(r.aead, idx, final), r.cbuf, nil); err != nil {
+		r.idx = -1
+		return DecryptErr
+	}
+	r.idx = idx
+	r.final = r.final || final
+	return
+}
+
+func (r *decryptReader) Read(p []byte) (n int, err error) {
+	if r.pos >= r.size {
+		// authenticate the final chunk, to detect truncation
+		if !r.final {
+			if err = r.load(r.size / blobCryptChunk); err != nil {
+				return
+			}
+		}
+		return 0, io.EOF
+	}
+	if idx := r.pos / blobCryptChunk; idx != r.idx {
+		if err = r.load(idx); err != nil {
+			return
+		}
+	}
+	n = copy(p, r.buf[r.pos-r.idx*blobCryptChunk:])
+	r.pos += int64(n)
+	return
+}
+
+func (r *decryptReader) Seek(offset int64, whence int) (n int64, err error) {
+	switch whence {
+	case io.SeekStart:
+		n = offset
+	case io.SeekCurrent:
+		n = r.pos + offset
+	case io.SeekEnd:
+		n = r.size + offset
+	}
+	if n < 0 {
+		return 0, errors.New("simpleblobstore: seek to negative position")
+	}
+	r.pos = n
+	return
+}
+
+func (r *decryptReader) Close() error {
+	return r.f.Close()
+}
+
+// Rewrap re-wraps the data key of an encrypted blob with the current KEK,
+// e.g. after a KEK rotation. Its content is not re-encrypted.
+//
+// It does nothing if the blob is already wrapped by the current KEK,
+// or is not encrypted (and Encryption.AllowPlaintext is set).
+func (l BlobDriver) Rewrap(ctx context.Context, key string) (err error) {
+	defer errorutil.OnError(&err)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if _, err = blobKeyString(key).Bytes(); err != nil {
+		return
+	}
+	if l.Encryption == nil || l.Encryption.Current == nil {
+		return UnknownKEKErr
+	}
+	b := l.backend()
+	p := blobKeyString(key).Path()
+	f, err := b.Open(p)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	kid, dataKey, hdrLen, err := l.Encryption.readHeader(f)
+	if err != nil || dataKey == nil || kid == l.Encryption.Current.ID() {
+		return
+	}
+	hdr, err := l.Encryption.header(dataKey)
+	if err != nil {
+		return
+	}
+	w, err := b.PutTemp(blobTempPrefix)
+	if err != nil {
+		return
+	}
+	if _, err = f.Seek(hdrLen, io.SeekStart); err == nil {
+		_, err = io.Copy(w, io.MultiReader(bytes.NewReader(hdr), f))
+	}
+	if err2 := w.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		b.Delete(w.Name())
+		return
+	}
+	return b.Commit(w.Name(), p)
+}
@@ -0,0 +1,149 @@
+package simpleblobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func testKEK(t *testing.T, id string) KEK {
+	k, err := NewAESKEK(id, bytes.Repeat([]byte(id[:1]), 32))
+	testutil.CheckErr(t, err)
+	return k
+}
+
+func testReadBlob(l BlobDriver, key string) (s string, err error) {
+	r, err := l.BlobReader(context.Background(), key)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	bs, err := io.ReadAll(r)
+	s = string(bs)
+	return
+}
+
+// testRewriteObject replaces the stored object at path p with the result of fn.
+func testRewriteObject(t *testing.T, b Backend, p string, fn func([]byte) []byte) {
+	f, err := b.Open(p)
+	testutil.CheckErr(t, err)
+	bs, err := io.ReadAll(f)
+	testutil.CheckErr(t, err)
+	f.Close()
+	w, err := b.PutTemp(blobTempPrefix)
+	testutil.CheckErr(t, err)
+	w.Write(fn(bs))
+	testutil.CheckErr(t, w.Close())
+	testutil.CheckErr(t, b.Commit(w.Name(), p))
+}
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBlobBackends(t) {
+		l := BlobDriver{Backend: b, Encryption: &Encryption{Current: testKEK(t, "k1")}}
+		for _, n := range []int{0, 10, blobCryptChunk, blobCryptChunk + 1, 3*blobCryptChunk + 100} {
+			content := strings.Repeat("0123456789abcdef", n/16+1)[:n]
+			key := testPutBlob(t, l, "text/plain", content)
+			s, err := testReadBlob(l, key)
+			testutil.CheckErr(t, err)
+			testutil.CheckEqual(t, s == content, true, name+": content")
+
+			// the stored object does not contain the content
+			f, err := b.Open(blobKeyString(key).Path())
+			testutil.CheckErr(t, err)
+			bs, _ := io.ReadAll(f)
+			f.Close()
+			if n > 0 && bytes.Contains(bs, []byte(content[:min(n, 32)])) {
+				testutil.Log(t, "%s: stored object is not encrypted", name)
+				testutil.Fail(t)
+			}
+
+			// seek into the middle of a chunk
+			r, err := l.BlobReader(ctx, key)
+			testutil.CheckErr(t, err)
+			end, _ := r.Seek(0, io.SeekEnd)
+			testutil.CheckEqual(t, end, int64(n), name+": size")
+			r.Seek(int64(n/2), io.SeekStart)
+			bs, err = io.ReadAll(r)
+			testutil.CheckErr(t, err)
+			r.Close()
+			testutil.CheckEqual(t, string(bs) == content[n/2:], true, name+": content after seek")
+		}
+
+		key := testPutBlob(t, l, "text/plain", strings.Repeat("x", blobCryptChunk+100))
+		p := blobKeyString(key).Path()
+		// tampering and truncation are detected
+		testRewriteObject(t, b, p, func(bs []byte) []byte { bs[len(bs)-20] ^= 1; return bs })
+		_, err := testReadBlob(l, key)
+		testutil.CheckEqual(t, errors.Is(err, DecryptErr), true, name+": tampered")
+		testRewriteObject(t, b, p, func(bs []byte) []byte { return bs[:len(bs)-116] })
+		_, err = testReadBlob(l, key)
+		testutil.CheckEqual(t, errors.Is(err, DecryptErr), true, name+": truncated")
+	}
+}
+
+func TestEncryptionRotation(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemBackend()
+	k1, k2 := testKEK(t, "k1"), testKEK(t, "k2")
+	l := BlobDriver{Backend: b, Encryption: &Encryption{Current: k1}}
+	key := testPutBlob(t, l, "text/plain", "rotate me")
+
+	l.Encryption = &Encryption{Current: k2, Previous: []KEK{k1}}
+	s, err := testReadBlob(l, key)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, s, "rotate me", "content with previous kek")
+
+	testutil.CheckErr(t, l.Rewrap(ctx, key))
+	l.Encryption = &Encryption{Current: k2}
+	s, err = testReadBlob(l, key)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, s, "rotate me", "content after rewrap")
+
+	l.Encryption = &Encryption{Current: k1}
+	_, err = testReadBlob(l, key)
+	testutil.CheckEqual(t, errors.Is(err, UnknownKEKErr), true, "unknown kek")
+
+	// blobs written before encryption was enabled are only readable if plaintext is allowed
+	key = testPutBlob(t, BlobDriver{Backend: b}, "text/plain", "plain")
+	_, err = testReadBlob(l, key)
+	testutil.CheckEqual(t, errors.Is(err, DecryptErr), true, "unencrypted content rejected")
+	l.Encryption.AllowPlaintext = true
+	s, err = testReadBlob(l, key)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, s, "plain", "unencrypted content")
+	testutil.CheckErr(t, l.Rewrap(ctx, key))
+}
+
+func TestEncryptionDedupUpload(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemBackend()
+	l := BlobDriver{Backend: b, Dedup: true, Encryption: &Encryption{Current: testKEK(t, "k1")}}
+	k1 := testPutBlob(t, l, "text/plain", "same")
+	k2 := testPutBlob(t, l, "text/plain", "same")
+	testutil.CheckEqual(t, blobKeyString(k1).Path(), blobKeyString(k2).Path(), "dedup path")
+	s, err := testReadBlob(l, k2)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, s, "same", "dedup content")
+
+	id, err := l.CreateUpload(ctx, "text/plain")
+	testutil.CheckErr(t, err)
+	off, err := l.AppendUpload(ctx, id, 0, strings.NewReader("up"), nil)
+	testutil.CheckErr(t, err)
+	off, err = l.AppendUpload(ctx, id, off, strings.NewReader("load"), nil)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, off, int64(6), "upload offset")
+	u, err := l.UploadStatus(ctx, id)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, u.Offset, int64(6), "upload committed offset")
+	key, err := l.FinishUpload(ctx, id)
+	testutil.CheckErr(t, err)
+	s, err = testReadBlob(l, key)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, s, "upload", "upload content")
+}
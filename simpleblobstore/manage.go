@@ -0,0 +1,213 @@
+package simpleblobstore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"iter"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ugorji/go-common/errorutil"
+)
+
+var errStopList = errorutil.String("simpleblobstore: stop listing")
+
+// ListQuery selects the blobs returned by List.
+type ListQuery struct {
+	// Shard limits the listing to the blobs in a shard directory, e.g. "3f" or "3f/a2/07".
+	// For content-addressed blobs, the shard is the prefix of the hash.
+	Shard string
+	// From and To limit the listing to blobs created in [From, To), if non-zero.
+	// The creation time is encoded in the key, so this does not read the blobs.
+	From, To time.Time
+}
+
+// GCOptions configures a GC run.
+type GCOptions struct {
+	// Live reports whether a key is still in use.
+	// If nil, no blobs are removed (only stale temp files).
+	Live func(key string) bool
+	// MinAge is how old an object must be before it can be removed.
+	// It protects in-flight writes, and blobs written after the set of live keys was computed.
+	MinAge time.Duration
+}
+
+// GCStats holds the results of a GC run.
+type GCStats struct {
+	TempFiles int   // stale temp files removed
+	Blobs     int   // orphaned blobs removed (including unreferenced content-addressed blobs)
+	Refs      int   // orphaned references to content-addressed blobs removed
+	Uploads   int   // expired upload sessions removed
+	Bytes     int64 // total size of the removed objects
+}
+
+// blobKeyOfPath returns the key for the blob (or reference to a content-addressed blob)
+// stored at the path p. ok is false for other objects (e.g. temp files or content-addressed data).
+func blobKeyOfPath(p string) (key string, ok bool) {
+	if i := strings.Index(p, blobRefsSuffix); i >= 0 {
+		key = strings.ReplaceAll(p[:i], "/", "") + "." + p[i+len(blobRefsSuffix):]
+	} else if strings.Count(p, "/") == 3 {
+		key = strings.ReplaceAll(p, "/", "")
+	} else {
+		return
+	}
+	_, err := blobKeyString(key).Bytes()
+	ok = err == nil
+	return
+}
+
+func isTempPath(p string) bool {
+	return strings.HasPrefix(path.Base(p), blobTempPrefix)
+}
+
+// isContentPath returns true if p holds the data of content-addressed blobs.
+func isContentPath(p string) bool {
+	if strings.Count(p, "/") != 3 || strings.Contains(p, blobRefsSuffix) {
+		return false
+	}
+	sum := strings.ReplaceAll(p, "/", "")
+	_, err := hex.DecodeString(sum)
+	return len(sum) == 64 && err == nil
+}
+
+// Delete removes the blob with the given key.
+//
+// For a content-addressed blob, only this key's reference is removed,
+// and the content is removed along with its last reference.
+// This is serialized with concurrent writes of the same content, which keep it.
+func (l BlobDriver) Delete(ctx context.Context, key string) (err error) {
+	defer errorutil.OnError(&err)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	k := blobKeyString(key)
+	if _, err = k.Bytes(); err != nil {
+		return
+	}
+	b := l.backend()
+	if _, _, ok := k.dedup(); !ok {
+		return b.Delete(k.Path())
+	}
+	defer lockContent(k.Path())()
+	if err = b.Delete(k.refPath()); err != nil {
+		return
+	}
+	n, err := l.RefCount(ctx, key)
+	if err != nil || n > 0 {
+		return
+	}
+	if err = b.Delete(k.Path()); errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return
+}
+
+// List returns an iterator over the blobs matching the query.
+//
+// Blobs are streamed from the Backend as they are found, so the whole store
+// is never held in memory. If an error occurs, it is yielded (with a nil BlobInfo)
+// and iteration stops.
+func (l BlobDriver) List(ctx context.Context, q ListQuery) iter.Seq2[*BlobInfo, error] {
+	return func(yield func(*BlobInfo, error) bool) {
+		prefix := strings.Trim(q.Shard, "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		err := l.backend().List(prefix, func(oi ObjectInfo) (err error) {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			key, ok := blobKeyOfPath(oi.Path)
+			if !ok {
+				return
+			}
+			bi, err := l.BlobInfo(ctx, key)
+			if err != nil {
+				return
+			}
+			if (!q.From.IsZero() && bi.CreationTime.Before(q.From)) ||
+				(!q.To.IsZero() && !bi.CreationTime.Before(q.To)) {
+				return
+			}
+			if !yield(bi, nil) {
+				err = errStopList
+			}
+			return
+		})
+		if err != nil && err != errStopList {
+			yield(nil, err)
+		}
+	}
+}
+
+// GC removes stale temp files (left by writers which never finished),
+// expired upload sessions, and the blobs which are no longer live.
+//
+// Only objects older than opts.MinAge are removed.
+// Errors removing individual objects do not stop the GC; they are all returned.
+func (l BlobDriver) GC(ctx context.Context, opts GCOptions) (st GCStats, err error) {
+	b := l.backend()
+	cutoff := time.Now().Add(-opts.MinAge)
+	var merr errorutil.Multi
+	var contents []ObjectInfo
+	del := func(oi ObjectInfo, n *int) {
+		if err := b.Delete(oi.Path); err != nil {
+			merr = append(merr, err)
+			return
+		}
+		*n++
+		st.Bytes += oi.Size
+	}
+	err = b.List("", func(oi ObjectInfo) (err error) {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if !oi.ModTime.Before(cutoff) {
+			return
+		}
+		if isTempPath(oi.Path) {
+			del(oi, &st.TempFiles)
+			return
+		}
+		if opts.Live == nil {
+			return
+		}
+		if key, ok := blobKeyOfPath(oi.Path); ok {
+			if !opts.Live(key) {
+				if _, _, dedup := blobKeyString(key).dedup(); dedup {
+					del(oi, &st.Refs)
+				} else {
+					del(oi, &st.Blobs)
+				}
+			}
+		} else if isContentPath(oi.Path) {
+			// its references are listed after it, so check it at the end
+			contents = append(contents, oi)
+		}
+		return
+	})
+	if err != nil {
+		merr = append(merr, err)
+	}
+	for _, oi := range contents {
+		unlock := lockContent(oi.Path)
+		var n int
+		if err = b.List(oi.Path+blobRefsSuffix, func(ObjectInfo) error {
+			n++
+			return errStopList
+		}); err != nil && err != errStopList {
+			merr = append(merr, err)
+		} else if n == 0 {
+			del(oi, &st.Blobs)
+		}
+		unlock()
+	}
+	if st.Uploads, err = l.cleanUploads(ctx); err != nil {
+		merr = append(merr, err)
+	}
+	err = merr.NonNilError()
+	return
+}
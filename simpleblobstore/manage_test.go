@@ -0,0 +1,112 @@
+package simpleblobstore
+
+import (
+	"context"
+	"io"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func testListKeys(t *testing.T, l BlobDriver, q ListQuery) (keys []string) {
+	for bi, err := range l.List(context.Background(), q) {
+		testutil.CheckErr(t, err)
+		keys = append(keys, bi.Key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+func TestDeleteAndList(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBlobBackends(t) {
+		for _, dedup := range []bool{false, true} {
+			l := BlobDriver{Backend: b, Dedup: dedup}
+			for bi := range l.List(ctx, ListQuery{}) {
+				l.Delete(ctx, bi.Key)
+			}
+			k1 := testPutBlob(t, l, "text/plain", "one")
+			t1 := time.Now()
+			time.Sleep(time.Millisecond)
+			k2 := testPutBlob(t, l, "text/plain", "two")
+			k3 := testPutBlob(t, l, "text/plain", "two")
+
+			all := []string{k1, k2, k3}
+			sort.Strings(all)
+			testutil.CheckEqual(t, testListKeys(t, l, ListQuery{}), all, name+": list all")
+			testutil.CheckEqual(t, testListKeys(t, l, ListQuery{To: t1}), []string{k1}, name+": list before")
+			testutil.CheckEqual(t, len(testListKeys(t, l, ListQuery{From: t1})), 2, name+": list after")
+			for _, k := range testListKeys(t, l, ListQuery{Shard: k1[:2] + "/" + k1[2:4]}) {
+				testutil.CheckEqual(t, k[:4], k1[:4], name+": list shard")
+			}
+			testutil.CheckEqual(t, len(testListKeys(t, l, ListQuery{Shard: "zz"})), 0, name+": list empty shard")
+
+			// stop early
+			var n int
+			for range l.List(ctx, ListQuery{}) {
+				n++
+				break
+			}
+			testutil.CheckEqual(t, n, 1, name+": list break")
+
+			testutil.CheckErr(t, l.Delete(ctx, k2))
+			if _, err := l.BlobReader(ctx, k2); err == nil {
+				testutil.Log(t, "%s: expecting error reading deleted blob", name)
+				testutil.Fail(t)
+			}
+			// with dedup, k3 still has the content
+			r, err := l.BlobReader(ctx, k3)
+			testutil.CheckErr(t, err)
+			bs, _ := io.ReadAll(r)
+			r.Close()
+			testutil.CheckEqual(t, string(bs), "two", name+": content")
+			testutil.CheckErr(t, l.Delete(ctx, k3))
+			testutil.CheckEqual(t, testListKeys(t, l, ListQuery{}), []string{k1}, name+": list after delete")
+		}
+	}
+}
+
+func TestGC(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBlobBackends(t) {
+		l := BlobDriver{Backend: b}
+		ld := BlobDriver{Backend: b, Dedup: true}
+		k1 := testPutBlob(t, l, "text/plain", "live")
+		k2 := testPutBlob(t, l, "text/plain", "dead")
+		k3 := testPutBlob(t, ld, "text/plain", "shared")
+		k4 := testPutBlob(t, ld, "text/plain", "shared")
+		k5 := testPutBlob(t, ld, "text/plain", "dead shared")
+		tw, err := b.PutTemp(blobTempPrefix)
+		testutil.CheckErr(t, err)
+		io.WriteString(tw, "abandoned")
+		tw.Close()
+
+		live := map[string]bool{k1: true, k3: true}
+		// nothing is old enough
+		st, err := l.GC(ctx, GCOptions{Live: func(k string) bool { return live[k] }, MinAge: time.Hour})
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, st, GCStats{}, name+": gc min age")
+
+		time.Sleep(2 * time.Millisecond)
+		st, err = l.GC(ctx, GCOptions{Live: func(k string) bool { return live[k] }, MinAge: time.Millisecond})
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, st.TempFiles, 1, name+": temp files")
+		testutil.CheckEqual(t, st.Blobs, 2, name+": blobs") // k2, and content of k5
+		testutil.CheckEqual(t, st.Refs, 2, name+": refs")   // k4, k5
+
+		all := []string{k1, k3}
+		sort.Strings(all)
+		testutil.CheckEqual(t, testListKeys(t, l, ListQuery{}), all, name+": list after gc")
+		for _, k := range []string{k2, k4, k5} {
+			if _, err = l.BlobReader(ctx, k); err == nil {
+				testutil.Log(t, "%s: expecting error reading collected blob: %s", name, k)
+				testutil.Fail(t)
+			}
+		}
+		n, err := l.RefCount(ctx, k3)
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, n, 1, name+": refcount")
+	}
+}
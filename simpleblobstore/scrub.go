@@ -0,0 +1,268 @@
+package simpleblobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ugorji/go-common/errorutil"
+)
+
+// ScrubProblem is the kind of problem found by Scrub.
+type ScrubProblem string
+
+const (
+	// ScrubCorrupt is a blob whose content does not match its key
+	// (e.g. a truncated file), or a reference to missing content.
+	ScrubCorrupt ScrubProblem = "corrupt"
+	// ScrubOrphaned is an object in the shard tree which is not a blob,
+	// or content-addressed data which no key refers to.
+	ScrubOrphaned ScrubProblem = "orphaned"
+	// ScrubMisplaced is a blob which is not stored at the path for its key.
+	ScrubMisplaced ScrubProblem = "misplaced"
+	// ScrubSkipped is an encrypted blob which could not be checked, as BlobDriver.Encryption is not set.
+	// It is never moved.
+	ScrubSkipped ScrubProblem = "skipped"
+)
+
+const blobQuarantinePrefix = "ndb-blobw-quarantine/"
+
+// ScrubOptions configures a Scrub run.
+type ScrubOptions struct {
+	// Verify reads the content of every blob, to verify its hash
+	// (for content-addressed blobs) or authenticate it (for encrypted blobs).
+	Verify bool
+	// Quarantine moves corrupt and orphaned objects to the quarantine directory
+	// (ndb-blobw-quarantine/ under the store), keeping their path.
+	Quarantine bool
+	// Repair moves misplaced blobs to their correct path.
+	Repair bool
+	// MinAge skips objects modified more recently than this, as they may still be in flux.
+	MinAge time.Duration
+}
+
+// ScrubFinding describes a problem found by Scrub.
+type ScrubFinding struct {
+	Path    string       `json:"path"`
+	Key     string       `json:"key,omitempty"`
+	Problem ScrubProblem `json:"problem"`
+	Detail  string       `json:"detail,omitempty"`
+	// MovedTo is where the object was moved to (if quarantined or repaired).
+	MovedTo string `json:"movedTo,omitempty"`
+	// Error is set if the object could not be moved.
+	Error string `json:"error,omitempty"`
+
+	target string // correct path of a misplaced blob
+}
+
+// ScrubReport is the machine-readable result of a Scrub run.
+type ScrubReport struct {
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	Scanned  int            `json:"scanned"`
+	Findings []ScrubFinding `json:"findings"`
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *ScrubReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Scrub walks the whole store, checking every blob against its key:
+//   - its size must match the size encoded in the key
+//   - it must be stored at the path for its key
+//   - content-addressed data must have references, and references must have data
+//
+// Temp files and upload sessions are skipped (see GC).
+//
+// The findings are returned in the report, and the problem objects are
+// quarantined or repaired (moved) as configured in the options.
+// The error is only for failures which stopped the scrub (e.g. listing failed).
+func (l BlobDriver) Scrub(ctx context.Context, opts ScrubOptions) (r *ScrubReport, err error) {
+	defer errorutil.OnError(&err)
+	b := l.backend()
+	r = &ScrubReport{Started: time.Now(), Findings: []ScrubFinding{}}
+	cutoff := r.Started.Add(-opts.MinAge)
+	contents := make(map[string]ObjectInfo) // content-addressed data
+	refs := make(map[string][]string)       // content path -> keys referring to it
+	var bi BlobInfo
+	err = b.List("", func(oi ObjectInfo) (err error) {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		p := oi.Path
+		if isTempPath(p) || strings.HasPrefix(p, blobUploadPrefix) ||
+			strings.HasPrefix(p, blobQuarantinePrefix) || !oi.ModTime.Before(cutoff) {
+			return
+		}
+		r.Scanned++
+		if key, ok := blobKeyOfPath(p); ok {
+			k := blobKeyString(key)
+			if _, _, dedup := k.dedup(); dedup {
+				if p != k.refPath() {
+					r.addMisplaced(p, key, k.refPath())
+				} else {
+					refs[k.Path()] = append(refs[k.Path()], key)
+				}
+				return
+			}
+			if p != k.Path() {
+				r.addMisplaced(p, key, k.Path())
+				return
+			}
+			bs, _ := k.Bytes()
+			blobKeyBytes(bs).LoadBlobInfo(&bi)
+			if problem, detail := l.scrubCheck(b, key, p, bi.Size, oi.Size, opts.Verify); problem != "" {
+				r.add(ScrubFinding{Path: p, Key: key, Problem: problem, Detail: detail})
+			}
+			return
+		}
+		if isContentPath(p) {
+			contents[p] = oi
+			return
+		}
+		// a blob file at the wrong depth (e.g. named with the full key)
+		for _, key := range []string{strings.ReplaceAll(p, "/", ""), path.Base(p)} {
+			if _, err2 := blobKeyString(key).Bytes(); err2 == nil {
+				target := blobKeyString(key).Path()
+				if _, _, dedup := blobKeyString(key).dedup(); dedup {
+					target = blobKeyString(key).refPath()
+				}
+				r.addMisplaced(p, key, target)
+				return
+			}
+		}
+		r.add(ScrubFinding{Path: p, Problem: ScrubOrphaned, Detail: "not a blob"})
+		return
+	})
+	if err != nil {
+		return
+	}
+	for p, oi := range contents {
+		keys := refs[p]
+		if len(keys) == 0 {
+			r.add(ScrubFinding{Path: p, Problem: ScrubOrphaned, Detail: "content with no references"})
+			continue
+		}
+		bs, _ := blobKeyString(keys[0]).Bytes()
+		blobKeyBytes(bs).LoadBlobInfo(&bi)
+		problem, detail := l.scrubCheck(b, keys[0], p, bi.Size, oi.Size, opts.Verify)
+		if problem == ScrubSkipped {
+			r.add(ScrubFinding{Path: p, Problem: problem, Detail: detail})
+		} else if problem != "" {
+			for _, key := range keys {
+				r.add(ScrubFinding{Path: blobKeyString(key).refPath(), Key: key, Problem: ScrubCorrupt, Detail: detail})
+			}
+			r.add(ScrubFinding{Path: p, Problem: ScrubCorrupt, Detail: detail})
+		}
+	}
+	for p, keys := range refs {
+		if _, ok := contents[p]; ok {
+			continue
+		}
+		// the content may just be newer than the cutoff
+		if _, err2 := b.Stat(p); err2 == nil {
+			continue
+		}
+		for _, key := range keys {
+			r.add(ScrubFinding{Path: blobKeyString(key).refPath(), Key: key, Problem: ScrubCorrupt, Detail: "missing content"})
+		}
+	}
+	sort.Slice(r.Findings, func(i, j int) bool { return r.Findings[i].Path < r.Findings[j].Path })
+	for i := range r.Findings {
+		l.scrubMove(b, &r.Findings[i], opts)
+	}
+	r.Finished = time.Now()
+	return
+}
+
+func (r *ScrubReport) add(f ScrubFinding) {
+	r.Findings = append(r.Findings, f)
+}
+
+func (r *ScrubReport) addMisplaced(p, key, target string) {
+	r.add(ScrubFinding{Path: p, Key: key, Problem: ScrubMisplaced, Detail: "expected at " + target, target: target})
+}
+
+// isEncryptedPath returns true if the object at p starts with the encryption header magic.
+func isEncryptedPath(b Backend, p string) bool {
+	f, err := b.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	bs := make([]byte, len(blobCryptMagic))
+	_, err = io.ReadFull(f, bs)
+	return err == nil && string(bs) == blobCryptMagic
+}
+
+// scrubCheck checks a stored blob, returning the problem (if any) and its description.
+//
+// Without Encryption, an encrypted blob cannot be checked (its stored size includes
+// the header and tags), so it is skipped instead of being reported as corrupt.
+func (l BlobDriver) scrubCheck(b Backend, key, p string, size, storedSize int64, verify bool,
+) (problem ScrubProblem, detail string) {
+	if l.Encryption == nil && isEncryptedPath(b, p) {
+		return ScrubSkipped, "encrypted, and no key-encryption key is configured"
+	}
+	if !verify && l.Encryption == nil {
+		if storedSize != size {
+			return ScrubCorrupt, fmt.Sprintf("size %d, expecting %d", storedSize, size)
+		}
+		return
+	}
+	// the stored size of an encrypted blob includes the header and tags, so decrypt it
+	f, err := l.open(b, p)
+	if err != nil {
+		return ScrubCorrupt, err.Error()
+	}
+	defer f.Close()
+	if _, _, ok := blobKeyString(key).dedup(); ok && verify {
+		f = &verifyReader{Reader: f, h: sha256.New(), sum: blobKeyString(key).sum(), verify: true}
+	}
+	var n int64
+	if verify {
+		n, err = io.Copy(io.Discard, f)
+	} else {
+		n, err = f.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		return ScrubCorrupt, err.Error()
+	}
+	if n != size {
+		return ScrubCorrupt, fmt.Sprintf("size %d, expecting %d", n, size)
+	}
+	return
+}
+
+func (l BlobDriver) scrubMove(b Backend, f *ScrubFinding, opts ScrubOptions) {
+	switch {
+	case f.Problem == ScrubMisplaced && opts.Repair:
+		f.MovedTo = f.target
+		if _, err := b.Stat(f.target); err == nil {
+			// the correct path is taken: keep that one, and quarantine this one
+			f.Detail += " (which already exists)"
+			if !opts.Quarantine {
+				f.MovedTo = ""
+				return
+			}
+			f.MovedTo = blobQuarantinePrefix + f.Path
+		}
+	case f.Problem != ScrubMisplaced && f.Problem != ScrubSkipped && opts.Quarantine:
+		f.MovedTo = blobQuarantinePrefix + f.Path
+	default:
+		return
+	}
+	if err := b.Commit(f.Path, f.MovedTo); err != nil {
+		f.Error = err.Error()
+		f.MovedTo = ""
+	}
+}
@@ -0,0 +1,123 @@
+package simpleblobstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func testPutObject(t *testing.T, b Backend, p, content string) {
+	w, err := b.PutTemp(blobTempPrefix)
+	testutil.CheckErr(t, err)
+	w.Write([]byte(content))
+	testutil.CheckErr(t, w.Close())
+	testutil.CheckErr(t, b.Commit(w.Name(), p))
+}
+
+func TestScrub(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBlobBackends(t) {
+		l := BlobDriver{Backend: b}
+		ld := BlobDriver{Backend: b, Dedup: true}
+		good := testPutBlob(t, l, "text/plain", "good")
+		truncated := testPutBlob(t, l, "text/plain", "truncated")
+		testRewriteObject(t, b, blobKeyString(truncated).Path(), func(bs []byte) []byte { return bs[:4] })
+		misplaced := testPutBlob(t, l, "text/plain", "misplaced")
+		testutil.CheckErr(t, b.Commit(blobKeyString(misplaced).Path(), misplaced))
+		dgood := testPutBlob(t, ld, "text/plain", "dedup")
+		dcorrupt := testPutBlob(t, ld, "text/plain", "dedup corrupt")
+		testRewriteObject(t, b, blobKeyString(dcorrupt).Path(), func(bs []byte) []byte { bs[0] ^= 1; return bs })
+		dorphan := testPutBlob(t, ld, "text/plain", "dedup orphan")
+		testutil.CheckErr(t, b.Delete(blobKeyString(dorphan).refPath()))
+		testPutObject(t, b, "ab/cd/junk", "junk")
+
+		// without verify, only sizes are checked: the flipped byte is not found
+		r, err := l.Scrub(ctx, ScrubOptions{})
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, r.Scanned, 9, name+": scanned")
+		problems := make(map[string]ScrubProblem)
+		for _, f := range r.Findings {
+			problems[f.Path] = f.Problem
+		}
+		testutil.CheckEqual(t, problems, map[string]ScrubProblem{
+			blobKeyString(truncated).Path(): ScrubCorrupt,
+			misplaced:                       ScrubMisplaced,
+			blobKeyString(dorphan).Path():   ScrubOrphaned,
+			"ab/cd/junk":                    ScrubOrphaned,
+		}, name+": findings")
+
+		r, err = l.Scrub(ctx, ScrubOptions{Verify: true, Quarantine: true, Repair: true})
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, len(r.Findings), 6, name+": findings with verify")
+		for _, f := range r.Findings {
+			if f.MovedTo == "" || f.Error != "" {
+				testutil.Log(t, "%s: expecting finding to be moved: %#v", name, f)
+				testutil.Fail(t)
+			}
+		}
+
+		// the report is machine-readable
+		var buf bytes.Buffer
+		testutil.CheckErr(t, r.WriteJSON(&buf))
+		var r2 ScrubReport
+		testutil.CheckErr(t, json.Unmarshal(buf.Bytes(), &r2))
+		testutil.CheckEqual(t, len(r2.Findings), len(r.Findings), name+": json findings")
+		if !strings.Contains(buf.String(), `"problem": "misplaced"`) {
+			testutil.Log(t, "%s: unexpected json report: %s", name, buf.String())
+			testutil.Fail(t)
+		}
+
+		// now clean: the misplaced blob is readable, bad objects are quarantined
+		r, err = l.Scrub(ctx, ScrubOptions{Verify: true})
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, len(r.Findings), 0, name+": findings after repair")
+		for _, k := range []string{good, misplaced, dgood} {
+			_, err = testReadBlob(l, k)
+			testutil.CheckErr(t, err)
+		}
+		var nq int
+		b.List(blobQuarantinePrefix, func(ObjectInfo) error { nq++; return nil })
+		testutil.CheckEqual(t, nq, 5, name+": quarantined")
+	}
+}
+
+func TestScrubEncryptedWithoutKey(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBlobBackends(t) {
+		enc := &Encryption{Current: testKEK(t, "k1")}
+		le := BlobDriver{Backend: b, Encryption: enc}
+		lde := BlobDriver{Backend: b, Dedup: true, Encryption: enc}
+		k := testPutBlob(t, le, "text/plain", "secret")
+		dk := testPutBlob(t, lde, "text/plain", "dedup secret")
+
+		// without a key, encrypted blobs cannot be checked: they are skipped, never quarantined
+		l := BlobDriver{Backend: b}
+		r, err := l.Scrub(ctx, ScrubOptions{Verify: true, Quarantine: true})
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, len(r.Findings), 2, name+": findings")
+		for _, f := range r.Findings {
+			if f.Problem != ScrubSkipped || f.MovedTo != "" {
+				testutil.Log(t, "%s: expecting finding to be skipped: %#v", name, f)
+				testutil.Fail(t)
+			}
+		}
+		r, err = l.Scrub(ctx, ScrubOptions{Quarantine: true})
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, len(r.Findings), 2, name+": findings without verify")
+		var nq int
+		b.List(blobQuarantinePrefix, func(ObjectInfo) error { nq++; return nil })
+		testutil.CheckEqual(t, nq, 0, name+": quarantined")
+
+		r, err = le.Scrub(ctx, ScrubOptions{Verify: true})
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, len(r.Findings), 0, name+": findings with key")
+		for _, key := range []string{k, dk} {
+			_, err = testReadBlob(le, key)
+			testutil.CheckErr(t, err)
+		}
+	}
+}
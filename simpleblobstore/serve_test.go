@@ -0,0 +1,75 @@
+package simpleblobstore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func TestBlobServe(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBlobBackends(t) {
+		for _, dedup := range []bool{false, true} {
+			l := BlobDriver{Backend: b, Dedup: dedup}
+			key := testPutBlob(t, l, "text/plain; charset=utf-8", "0123456789")
+			bi, err := l.BlobInfo(ctx, key)
+			testutil.CheckErr(t, err)
+
+			serve := func(hdrs ...string) *http.Response {
+				req := httptest.NewRequest("GET", "/blob", nil)
+				for i := 0; i < len(hdrs); i += 2 {
+					req.Header.Set(hdrs[i], hdrs[i+1])
+				}
+				w := httptest.NewRecorder()
+				testutil.CheckErr(t, l.BlobServe(ctx, key, w, req))
+				return w.Result()
+			}
+			body := func(resp *http.Response) string {
+				bs, err := io.ReadAll(resp.Body)
+				testutil.CheckErr(t, err)
+				return string(bs)
+			}
+
+			resp := serve()
+			testutil.CheckEqual(t, resp.StatusCode, 200, name+": status")
+			testutil.CheckEqual(t, body(resp), "0123456789", name+": body")
+			testutil.CheckEqual(t, resp.Header.Get("Content-Type"), "text/plain; charset=utf-8", name+": content type")
+			testutil.CheckEqual(t, resp.Header.Get("Content-Length"), "10", name+": content length")
+			testutil.CheckEqual(t, resp.Header.Get("ETag"), `"`+key+`"`, name+": etag")
+			lastMod := resp.Header.Get("Last-Modified")
+			testutil.CheckEqual(t, lastMod, bi.CreationTime.UTC().Format(http.TimeFormat), name+": last modified")
+
+			resp = serve("Range", "bytes=2-5")
+			testutil.CheckEqual(t, resp.StatusCode, http.StatusPartialContent, name+": range status")
+			testutil.CheckEqual(t, body(resp), "2345", name+": range body")
+			testutil.CheckEqual(t, resp.Header.Get("Content-Range"), "bytes 2-5/10", name+": content range")
+
+			resp = serve("If-None-Match", `"`+key+`"`)
+			testutil.CheckEqual(t, resp.StatusCode, http.StatusNotModified, name+": if-none-match")
+			resp = serve("If-None-Match", `"other"`)
+			testutil.CheckEqual(t, resp.StatusCode, 200, name+": if-none-match (changed)")
+
+			resp = serve("If-Modified-Since", lastMod)
+			testutil.CheckEqual(t, resp.StatusCode, http.StatusNotModified, name+": if-modified-since")
+			resp = serve("If-Modified-Since", bi.CreationTime.Add(-2*time.Second).UTC().Format(http.TimeFormat))
+			testutil.CheckEqual(t, resp.StatusCode, 200, name+": if-modified-since (changed)")
+
+			// without a request, the whole blob is served
+			w := httptest.NewRecorder()
+			testutil.CheckErr(t, l.BlobServe(ctx, key, w, nil))
+			testutil.CheckEqual(t, w.Body.String(), "0123456789", name+": body (nil request)")
+			testutil.CheckEqual(t, w.Header().Get("Content-Length"), strconv.Itoa(10), name+": content length (nil request)")
+
+			if err = l.BlobServe(ctx, "invalid", httptest.NewRecorder(), nil); err == nil {
+				testutil.Log(t, "%s: expecting error serving invalid key", name)
+				testutil.Fail(t)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Package serverapp adapts a simpleblobstore.Driver to the blob interfaces
+of github.com/ugorji/go-serverapp/app.
+
+It lives in its own package, so simpleblobstore can be used without
+depending on go-serverapp.
+*/
+package serverapp
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ugorji/go-common/simpleblobstore"
+	"github.com/ugorji/go-serverapp/app"
+)
+
+// Driver exposes a simpleblobstore.Driver using the app types.
+//
+// The app.Context is used as the context.Context if it implements it,
+// else context.Background() is used.
+type Driver struct {
+	D simpleblobstore.Driver
+}
+
+// New returns an adapter over d.
+func New(d simpleblobstore.Driver) Driver {
+	return Driver{d}
+}
+
+func toContext(c app.Context) context.Context {
+	if ctx, ok := c.(context.Context); ok && ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+// BlobInfoOf converts a simpleblobstore.BlobInfo to an app.BlobInfo.
+func BlobInfoOf(bi *simpleblobstore.BlobInfo) *app.BlobInfo {
+	if bi == nil {
+		return nil
+	}
+	return &app.BlobInfo{
+		Key:          bi.Key,
+		ContentType:  bi.ContentType,
+		CreationTime: bi.CreationTime,
+		Size:         bi.Size,
+	}
+}
+
+func (d Driver) BlobWriter(c app.Context, contentType string) (w app.BlobWriter, err error) {
+	w2, err := d.D.BlobWriter(toContext(c), contentType)
+	if err != nil {
+		return
+	}
+	w = w2
+	return
+}
+
+func (d Driver) BlobReader(c app.Context, key string) (r app.BlobReader, err error) {
+	r2, err := d.D.BlobReader(toContext(c), key)
+	if err != nil {
+		return
+	}
+	r = r2
+	return
+}
+
+func (d Driver) BlobInfo(c app.Context, key string) (bi *app.BlobInfo, err error) {
+	bi2, err := d.D.BlobInfo(toContext(c), key)
+	if err != nil {
+		return
+	}
+	bi = BlobInfoOf(bi2)
+	return
+}
+
+// BlobServe serves the whole blob, as the request is not available
+// (so Range and conditional requests are not supported).
+func (d Driver) BlobServe(c app.Context, key string, response http.ResponseWriter) error {
+	return d.D.BlobServe(toContext(c), key, response, nil)
+}
@@ -0,0 +1,39 @@
+package serverapp
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ugorji/go-common/simpleblobstore"
+	"github.com/ugorji/go-common/testutil"
+	"github.com/ugorji/go-serverapp/app"
+)
+
+func TestDriver(t *testing.T) {
+	var d interface {
+		BlobWriter(c app.Context, contentType string) (app.BlobWriter, error)
+		BlobReader(c app.Context, key string) (app.BlobReader, error)
+		BlobInfo(c app.Context, key string) (*app.BlobInfo, error)
+	} = New(simpleblobstore.BlobDriver{Backend: simpleblobstore.NewMemBackend()})
+
+	w, err := d.BlobWriter(context.Background(), "text/plain")
+	testutil.CheckErr(t, err)
+	_, err = io.WriteString(w, "hello app")
+	testutil.CheckErr(t, err)
+	key, err := w.Finish()
+	testutil.CheckErr(t, err)
+
+	bi, err := d.BlobInfo(nil, key)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, bi.Key, key, "key")
+	testutil.CheckEqual(t, bi.Size, int64(9), "size")
+	testutil.CheckEqual(t, bi.ContentType, "text/plain", "content type")
+
+	r, err := d.BlobReader(nil, key)
+	testutil.CheckErr(t, err)
+	defer r.Close()
+	bs, err := io.ReadAll(r)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, string(bs), "hello app", "content")
+}
@@ -0,0 +1,296 @@
+package simpleblobstore
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ugorji/go-common/errorutil"
+)
+
+// Resumable uploads.
+//
+// An upload session is stored in the Backend (so it survives a crash) as:
+//   ndb-blobw-upload/ID/meta                    (content is the content type)
+//   ndb-blobw-upload/ID/c00000000000000000000.1048576   (chunk at offset 0, of size 1048576)
+//   ndb-blobw-upload/ID/c00000000000001048576.65536     (chunk at offset 1048576, of size 65536)
+//   ...
+// Each chunk is written to a temp object and committed, so a chunk is either
+// fully there or not at all. The committed offset is the end of the last
+// contiguous chunk.
+//
+// A session expires after BlobDriver.UploadTTL without any activity.
+// Expired sessions are removed when next accessed, and by GC.
+
+var (
+	UploadNotFoundErr   = errorutil.String("simpleblobstore: upload session not found or expired")
+	UploadOffsetErr     = errorutil.String("simpleblobstore: upload chunk is not at the committed offset")
+	ChecksumMismatchErr = errorutil.String("simpleblobstore: upload chunk does not match its checksum")
+)
+
+const (
+	blobUploadPrefix     = "ndb-blobw-upload/"
+	blobUploadMeta       = "meta"
+	blobUploadTTLDefault = 24 * time.Hour
+)
+
+// UploadInfo describes an upload session.
+type UploadInfo struct {
+	ID          string
+	ContentType string
+	Offset      int64     // committed offset i.e. number of bytes received
+	Updated     time.Time // time of the last activity
+	chunks      []ObjectInfo
+}
+
+func (l BlobDriver) uploadTTL() time.Duration {
+	if l.UploadTTL > 0 {
+		return l.UploadTTL
+	}
+	return blobUploadTTLDefault
+}
+
+func uploadDir(id string) string {
+	return blobUploadPrefix + id + "/"
+}
+
+// CreateUpload starts a new upload session, and returns its id.
+func (l BlobDriver) CreateUpload(ctx context.Context, contentType string) (id string, err error) {
+	defer errorutil.OnError(&err)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	bs := make([]byte, 16)
+	if _, err = crand.Read(bs); err != nil {
+		return
+	}
+	id = hex.EncodeToString(bs)
+	b := l.backend()
+	w, err := b.PutTemp(blobTempPrefix)
+	if err != nil {
+		return
+	}
+	if _, err = io.WriteString(w, contentType); err != nil {
+		w.Close()
+		b.Delete(w.Name())
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	err = b.Commit(w.Name(), uploadDir(id)+blobUploadMeta)
+	return
+}
+
+// UploadStatus returns the state of an upload session,
+// including the committed offset at which the next chunk must be appended.
+func (l BlobDriver) UploadStatus(ctx context.Context, id string) (u *UploadInfo, err error) {
+	defer errorutil.OnError(&err)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return l.upload(id)
+}
+
+func (l BlobDriver) upload(id string) (u *UploadInfo, err error) {
+	if _, err = hex.DecodeString(id); err != nil || id == "" {
+		err = UploadNotFoundErr
+		return
+	}
+	b := l.backend()
+	var meta *ObjectInfo
+	var chunks []ObjectInfo
+	dir := uploadDir(id)
+	if err = b.List(dir, func(oi ObjectInfo) error {
+		if name := oi.Path[len(dir):]; name == blobUploadMeta {
+			meta = &oi
+		} else if strings.HasPrefix(name, "c") {
+			chunks = append(chunks, oi)
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+	if meta == nil {
+		err = UploadNotFoundErr
+		return
+	}
+	u = &UploadInfo{ID: id, Updated: meta.ModTime}
+	// chunks are listed in order of offset (which is zero-padded)
+	for _, oi := range chunks {
+		// the size in the name is the size of the content (which may be stored encrypted)
+		name, size, _ := strings.Cut(oi.Path[len(dir)+1:], ".")
+		off, err2 := strconv.ParseInt(name, 10, 64)
+		n, err3 := strconv.ParseInt(size, 10, 64)
+		if err2 != nil || err3 != nil || off != u.Offset {
+			break
+		}
+		u.Offset += n
+		u.chunks = append(u.chunks, oi)
+		if oi.ModTime.After(u.Updated) {
+			u.Updated = oi.ModTime
+		}
+	}
+	if time.Since(u.Updated) > l.uploadTTL() {
+		l.removeUpload(id)
+		u, err = nil, UploadNotFoundErr
+		return
+	}
+	f, err := b.Open(meta.Path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	bs, err := io.ReadAll(f)
+	u.ContentType = string(bs)
+	return
+}
+
+// AppendUpload appends a chunk to an upload session, at the given offset
+// (which must be the committed offset), and returns the new committed offset.
+//
+// If sum is non-nil, it is the SHA-256 of the chunk, and the chunk is rejected
+// if it does not match. Appending to a session must not be done concurrently.
+func (l BlobDriver) AppendUpload(ctx context.Context, id string, offset int64,
+	chunk io.Reader, sum []byte) (newOffset int64, err error) {
+	defer errorutil.OnError(&err)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	u, err := l.upload(id)
+	if err != nil {
+		return
+	}
+	if offset != u.Offset {
+		err = fmt.Errorf("%w: got %d, expecting %d", UploadOffsetErr, offset, u.Offset)
+		return
+	}
+	b := l.backend()
+	w, err := l.putTemp(b)
+	if err != nil {
+		return
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), chunk)
+	if err2 := w.Close(); err == nil {
+		err = err2
+	}
+	if err == nil && sum != nil && !bytes.Equal(h.Sum(nil), sum) {
+		err = ChecksumMismatchErr
+	}
+	if err == nil && n > 0 {
+		err = b.Commit(w.Name(), fmt.Sprintf("%sc%020d.%d", uploadDir(id), offset, n))
+	} else {
+		b.Delete(w.Name())
+	}
+	if err != nil {
+		return
+	}
+	newOffset = offset + n
+	return
+}
+
+// FinishUpload writes the uploaded chunks into a new blob, removes the session,
+// and returns the key of the blob.
+func (l BlobDriver) FinishUpload(ctx context.Context, id string) (key string, err error) {
+	defer errorutil.OnError(&err)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	u, err := l.upload(id)
+	if err != nil {
+		return
+	}
+	w, err := l.BlobWriter(ctx, u.ContentType)
+	if err != nil {
+		return
+	}
+	b := l.backend()
+	for _, oi := range u.chunks {
+		var f Reader
+		if f, err = l.open(b, oi.Path); err != nil {
+			return
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return
+		}
+	}
+	if key, err = w.Finish(); err != nil {
+		return
+	}
+	l.removeUpload(id)
+	return
+}
+
+// AbortUpload removes an upload session.
+func (l BlobDriver) AbortUpload(ctx context.Context, id string) (err error) {
+	defer errorutil.OnError(&err)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if _, err = hex.DecodeString(id); err != nil || id == "" {
+		return UploadNotFoundErr
+	}
+	return l.removeUpload(id)
+}
+
+func (l BlobDriver) removeUpload(id string) error {
+	b := l.backend()
+	var paths []string
+	err := b.List(uploadDir(id), func(oi ObjectInfo) error {
+		// remove the meta last, so a partially removed session is still found (and removed) later
+		if strings.HasSuffix(oi.Path, "/"+blobUploadMeta) {
+			paths = append(paths, oi.Path)
+		} else {
+			paths = append([]string{oi.Path}, paths...)
+		}
+		return nil
+	})
+	var merr errorutil.Multi
+	merr = append(merr, err)
+	for _, p := range paths {
+		if err = b.Delete(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			merr = append(merr, err)
+		}
+	}
+	return merr.NonNilError()
+}
+
+// cleanUploads removes the expired upload sessions, returning how many were removed.
+func (l BlobDriver) cleanUploads(ctx context.Context) (n int, err error) {
+	updated := make(map[string]time.Time)
+	if err = l.backend().List(blobUploadPrefix, func(oi ObjectInfo) error {
+		if i := strings.IndexByte(oi.Path[len(blobUploadPrefix):], '/'); i > 0 {
+			id := oi.Path[len(blobUploadPrefix) : len(blobUploadPrefix)+i]
+			if oi.ModTime.After(updated[id]) {
+				updated[id] = oi.ModTime
+			}
+		}
+		return ctx.Err()
+	}); err != nil {
+		return
+	}
+	var merr errorutil.Multi
+	for id, t := range updated {
+		if time.Since(t) > l.uploadTTL() {
+			if err = l.removeUpload(id); err != nil {
+				merr = append(merr, err)
+			} else {
+				n++
+			}
+		}
+	}
+	err = merr.NonNilError()
+	return
+}
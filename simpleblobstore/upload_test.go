@@ -0,0 +1,85 @@
+package simpleblobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+func TestUpload(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBlobBackends(t) {
+		l := BlobDriver{Backend: b}
+		id, err := l.CreateUpload(ctx, "text/plain")
+		testutil.CheckErr(t, err)
+
+		chunks := []string{"hello ", "resumable ", "world"}
+		var off int64
+		for _, c := range chunks[:2] {
+			sum := sha256.Sum256([]byte(c))
+			off, err = l.AppendUpload(ctx, id, off, strings.NewReader(c), sum[:])
+			testutil.CheckErr(t, err)
+		}
+
+		// a bad checksum or offset is rejected, and does not change the committed offset
+		sum := sha256.Sum256([]byte("other"))
+		_, err = l.AppendUpload(ctx, id, off, strings.NewReader(chunks[2]), sum[:])
+		testutil.CheckEqual(t, errors.Is(err, ChecksumMismatchErr), true, name+": checksum mismatch")
+		_, err = l.AppendUpload(ctx, id, 3, strings.NewReader(chunks[2]), nil)
+		testutil.CheckEqual(t, errors.Is(err, UploadOffsetErr), true, name+": offset mismatch")
+
+		// "resume" with a new driver over the same backend
+		l = BlobDriver{Backend: b}
+		u, err := l.UploadStatus(ctx, id)
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, u.Offset, int64(16), name+": committed offset")
+		testutil.CheckEqual(t, u.ContentType, "text/plain", name+": content type")
+		off, err = l.AppendUpload(ctx, id, u.Offset, strings.NewReader(chunks[2]), nil)
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, off, int64(21), name+": final offset")
+
+		key, err := l.FinishUpload(ctx, id)
+		testutil.CheckErr(t, err)
+		r, err := l.BlobReader(ctx, key)
+		testutil.CheckErr(t, err)
+		bs, _ := io.ReadAll(r)
+		r.Close()
+		testutil.CheckEqual(t, string(bs), strings.Join(chunks, ""), name+": content")
+		bi, err := l.BlobInfo(ctx, key)
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, bi.ContentType, "text/plain", name+": blob content type")
+
+		_, err = l.UploadStatus(ctx, id)
+		testutil.CheckEqual(t, errors.Is(err, UploadNotFoundErr), true, name+": finished session")
+	}
+}
+
+func TestUploadExpiry(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBlobBackends(t) {
+		l := BlobDriver{Backend: b, UploadTTL: time.Millisecond}
+		id1, err := l.CreateUpload(ctx, "text/plain")
+		testutil.CheckErr(t, err)
+		id2, err := l.CreateUpload(ctx, "text/plain")
+		testutil.CheckErr(t, err)
+		_, err = l.AppendUpload(ctx, id2, 0, strings.NewReader("abc"), nil)
+		testutil.CheckErr(t, err)
+		time.Sleep(5 * time.Millisecond)
+
+		_, err = l.AppendUpload(ctx, id1, 0, strings.NewReader("abc"), nil)
+		testutil.CheckEqual(t, errors.Is(err, UploadNotFoundErr), true, name+": expired session")
+
+		st, err := l.GC(ctx, GCOptions{})
+		testutil.CheckErr(t, err)
+		testutil.CheckEqual(t, st.Uploads, 1, name+": expired sessions removed by gc")
+		var n int
+		b.List(blobUploadPrefix, func(ObjectInfo) error { n++; return nil })
+		testutil.CheckEqual(t, n, 0, name+": upload objects left")
+	}
+}
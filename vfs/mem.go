@@ -2,17 +2,24 @@ package vfs
 
 import (
 	"path"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 )
 
+// MemFS is a FileSystem that serves files out of memory.
+//
+// Use NewMemFS to create one, or Snapshot to build one from any FS.
 type MemFS struct {
 	files  map[string]*MemFile
 	sealed bool
 }
 
+// NewMemFS returns a new empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*MemFile)}
+}
+
 func (x *MemFS) Close() error { return nil }
 
 func (x *MemFS) Open(name string) (f File, err error) {
@@ -25,24 +32,9 @@ func (x *MemFS) Open(name string) (f File, err error) {
 }
 
 func (x *MemFS) RootFiles() (infos []FileInfo, err error) {
-	return x.matchesInfo("", "*", -1)
-}
-
-func (x *MemFS) matchesInfo(basepath, pattern string, n int) (infos []FileInfo, err error) {
-	var matches bool
-	for k, v := range x.files {
-		if basepath == "" || strings.HasPrefix(k, basepath) {
-			k = k[len(basepath):]
-			matches, err = filepath.Match(k, pattern)
-			if err != nil {
-				return
-			}
-			if matches {
-				infos = append(infos, v)
-				if n > 0 && len(infos) == n {
-					return
-				}
-			}
+	for _, v := range x.files {
+		if v.parent == nil {
+			infos = append(infos, v)
 		}
 	}
 	return
@@ -78,6 +70,32 @@ func (x *MemFS) AddFile(parent *MemFile, name string, size int64, modTime time.T
 	return
 }
 
+// Add a file to the MemFS, given its full path (using / as separator).
+//
+// Unlike AddFile, the parent directories are looked up (or created) as needed.
+func (x *MemFS) Add(name string, modTime time.Time, content string) (m *MemFile) {
+	name = path.Clean(name)
+	return x.AddFile(x.mkdirAll(path.Dir(name), modTime), name, int64(len(content)), modTime, content)
+}
+
+// AddDir adds a directory (and its parents, if not already present) to the MemFS.
+func (x *MemFS) AddDir(name string, modTime time.Time) (m *MemFile) {
+	return x.mkdirAll(path.Clean(name), modTime)
+}
+
+func (x *MemFS) mkdirAll(name string, modTime time.Time) (m *MemFile) {
+	if name == "." || name == "/" || name == "" {
+		return nil
+	}
+	if m = x.files[name]; m != nil {
+		m.dir = true
+		return
+	}
+	m = x.AddFile(x.mkdirAll(path.Dir(name), modTime), name, 0, modTime, "")
+	m.dir = true
+	return
+}
+
 func (x *MemFS) GetFile(name string) *MemFile {
 	return x.files[path.Clean(name)]
 }
@@ -100,7 +118,7 @@ func (x *MemFS) Seal() {
 }
 
 func (x *MemFS) isDir(f *MemFile) bool {
-	if x.sealed {
+	if x.sealed || f.dir {
 		return f.dir
 	}
 	for _, v := range x.files {
@@ -156,4 +174,13 @@ type memFileReadCloser struct {
 func (x *memFileReadCloser) Read(p []byte) (n int, err error) { return x.r.Read(p) }
 func (x *memFileReadCloser) Close() error                     { return nil }
 
+func (x *memFileReadCloser) Seek(offset int64, whence int) (int64, error) {
+	return x.r.Seek(offset, whence)
+}
+
+func (x *memFileReadCloser) ReadAt(p []byte, off int64) (n int, err error) {
+	return x.r.ReadAt(p, off)
+}
+
 var _, _, _ = FS((*MemFS)(nil)), File((*memFileReadCloser)(nil)), FileInfo((*MemFile)(nil))
+var _, _ = WithSeek((*memFileReadCloser)(nil)), WithReadAt((*memFileReadCloser)(nil))
@@ -0,0 +1,133 @@
+package vfs
+
+import (
+	"archive/zip"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ugorji/go-common/errorutil"
+)
+
+// Snapshot reads every file and directory in the FS into a new MemFS.
+//
+// The returned MemFS is sealed, and is independent of the source FS
+// (which can be closed afterwards).
+func Snapshot(fs FS) (m *MemFS, err error) {
+	defer errorutil.OnError(&err)
+	names, err := fs.Matches(nil, nil, true)
+	if err != nil {
+		return
+	}
+	// sort, so parents are seen before their children
+	for i := range names {
+		names[i] = path.Clean(filepath.ToSlash(names[i]))
+	}
+	sort.Strings(names)
+	m = NewMemFS()
+	for _, name := range names {
+		if err = snapshotFile(m, fs, name); err != nil {
+			return nil, err
+		}
+	}
+	m.Seal()
+	return
+}
+
+func snapshotFile(m *MemFS, fs FS, name string) (err error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+	if fi.IsDir() {
+		m.AddDir(name, fi.ModTime())
+		return
+	}
+	var content string
+	if ri, ok := f.(WithReadImmutable); ok {
+		content, err = ri.ReadImmutable()
+	} else {
+		var bs []byte
+		bs, err = io.ReadAll(f)
+		content = string(bs)
+	}
+	if err != nil {
+		return
+	}
+	m.Add(name, fi.ModTime(), content)
+	return
+}
+
+// WriteZip serializes the MemFS as a zip file, which can be loaded back
+// via ReadMemFS (or served directly via a ZipFS).
+//
+// Entries are written in sorted order, so the output is deterministic.
+func (x *MemFS) WriteZip(w io.Writer) (err error) {
+	defer errorutil.OnError(&err)
+	names := make([]string, 0, len(x.files))
+	for k := range x.files {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	zw := zip.NewWriter(w)
+	var fw io.Writer
+	for _, k := range names {
+		v := x.files[k]
+		fh := &zip.FileHeader{Name: k, Modified: v.modTime, Method: zip.Deflate}
+		if x.isDir(v) {
+			fh.Name += "/"
+			fh.Method = zip.Store
+		}
+		if fw, err = zw.CreateHeader(fh); err != nil {
+			return
+		}
+		if _, err = io.WriteString(fw, v.content); err != nil {
+			return
+		}
+	}
+	return zw.Close()
+}
+
+// ReadMemFS loads a MemFS from a zip file (e.g. one created by MemFS.WriteZip).
+//
+// This allows a pre-built asset bundle to be embedded into a binary, and served from memory.
+func ReadMemFS(r io.ReaderAt, size int64) (m *MemFS, err error) {
+	defer errorutil.OnError(&err)
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return
+	}
+	m = NewMemFS()
+	var sb strings.Builder
+	for _, f := range zr.File {
+		name := path.Clean(f.Name)
+		if f.FileInfo().IsDir() {
+			m.AddDir(name, f.Modified)
+			continue
+		}
+		sb.Reset()
+		if err = readZipFileTo(&sb, f); err != nil {
+			return nil, err
+		}
+		m.Add(name, f.Modified, sb.String())
+	}
+	m.Seal()
+	return
+}
+
+func readZipFileTo(w io.Writer, f *zip.File) (err error) {
+	rc, err := f.Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return
+}
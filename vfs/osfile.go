@@ -11,7 +11,9 @@ type OsFS struct {
 	f *os.File
 }
 
-// osFile is an entry in a os file
+// osFile is an entry in a os file.
+//
+// It exposes all the capabilities of the *os.File (e.g. Seek, ReadAt).
 type osFile struct {
 	*os.File
 }
@@ -90,3 +92,4 @@ func (x *osFile) Stat() (info FileInfo, err error) {
 }
 
 var _, _, _ = FS((*OsFS)(nil)), File((*osFile)(nil)), FileInfo((os.FileInfo)(nil))
+var _, _ = WithSeek((*osFile)(nil)), WithReadAt((*osFile)(nil))
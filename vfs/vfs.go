@@ -37,6 +37,24 @@ type WithReadImmutable interface {
 	ReadImmutable() (string, error)
 }
 
+// WithSeek is implemented by Files which support seeking
+// (e.g. to serve HTTP ranges, or feed decoders which need an io.Seeker).
+//
+// See WithReadAt for the caveats on ZipFS files.
+type WithSeek interface {
+	io.Seeker
+}
+
+// WithReadAt is implemented by Files which support random access reads.
+//
+// All Files from OsFS, MemFS and ZipFS implement WithSeek and WithReadAt.
+// Stored (uncompressed) zip entries are read directly from the zip file.
+// Deflated (compressed) zip entries cannot be seeked, so the first call to Seek or ReadAt
+// will decompress the whole entry into memory, and serve subsequent calls from there.
+type WithReadAt interface {
+	io.ReaderAt
+}
+
 // WithReadDir is implemented by Directories
 // to get a listing of the files in them.
 type WithReadDir interface {
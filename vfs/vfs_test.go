@@ -0,0 +1,203 @@
+package vfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ugorji/go-common/testutil"
+)
+
+var testVfsFiles = map[string]string{
+	"a.txt":       "hello a",
+	"d1/b.txt":    "hello b",
+	"d1/d2/c.txt": "hello c",
+}
+
+func testVfsOsDir(t *testing.T) string {
+	dir := t.TempDir()
+	for k, v := range testVfsFiles {
+		fpath := filepath.Join(dir, filepath.FromSlash(k))
+		testutil.CheckErr(t, os.MkdirAll(filepath.Dir(fpath), 0777))
+		testutil.CheckErr(t, os.WriteFile(fpath, []byte(v), 0666))
+	}
+	return dir
+}
+
+func testVfsCheckContents(t *testing.T, fs FS) {
+	for k, v := range testVfsFiles {
+		f, err := fs.Open(k)
+		testutil.CheckErr(t, err)
+		bs, err := io.ReadAll(f)
+		testutil.CheckErr(t, err)
+		f.Close()
+		testutil.CheckEqual(t, string(bs), v, k)
+	}
+}
+
+func TestMemFSSnapshot(t *testing.T) {
+	osfs, err := NewOsFS(testVfsOsDir(t))
+	testutil.CheckErr(t, err)
+	defer osfs.Close()
+
+	m, err := Snapshot(osfs)
+	testutil.CheckErr(t, err)
+	testVfsCheckContents(t, m)
+	if fi := m.GetFile("d1/d2"); fi == nil || !fi.IsDir() {
+		testutil.Log(t, "Expecting d1/d2 to be a directory")
+		testutil.Fail(t)
+	}
+	roots, err := m.RootFiles()
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, len(roots), 2, "number of root files")
+
+	var buf bytes.Buffer
+	testutil.CheckErr(t, m.WriteZip(&buf))
+	m2, err := ReadMemFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	testutil.CheckErr(t, err)
+	testVfsCheckContents(t, m2)
+	testutil.CheckEqual(t, len(m2.files), len(m.files), "number of files after ReadMemFS")
+
+	// a snapshot of a snapshot should be identical
+	m3, err := Snapshot(m2)
+	testutil.CheckErr(t, err)
+	var buf3 bytes.Buffer
+	testutil.CheckErr(t, m3.WriteZip(&buf3))
+	if !bytes.Equal(buf.Bytes(), buf3.Bytes()) {
+		testutil.Log(t, "Expecting serialized snapshot of a snapshot to be identical")
+		testutil.Fail(t)
+	}
+}
+
+func TestZipFSSnapshot(t *testing.T) {
+	osfs, err := NewOsFS(testVfsOsDir(t))
+	testutil.CheckErr(t, err)
+	defer osfs.Close()
+	m, err := Snapshot(osfs)
+	testutil.CheckErr(t, err)
+
+	zpath := filepath.Join(t.TempDir(), "test.zip")
+	zf, err := os.Create(zpath)
+	testutil.CheckErr(t, err)
+	testutil.CheckErr(t, m.WriteZip(zf))
+	testutil.CheckErr(t, zf.Close())
+	zrc, err := zip.OpenReader(zpath)
+	testutil.CheckErr(t, err)
+	zfs := NewZipFS(zrc)
+	defer zfs.Close()
+
+	roots, err := zfs.RootFiles()
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, len(roots), 2, "number of zip root files")
+	f, err := zfs.Open("d1")
+	testutil.CheckErr(t, err)
+	infos, err := f.(WithReadDir).ReadDir(-1)
+	testutil.CheckErr(t, err)
+	f.Close()
+	testutil.CheckEqual(t, len(infos), 2, "number of zip files in d1")
+
+	m2, err := Snapshot(zfs)
+	testutil.CheckErr(t, err)
+	testVfsCheckContents(t, m2)
+	testutil.CheckEqual(t, len(m2.files), len(m.files), "number of files after snapshot of zip")
+	roots, err = m2.RootFiles()
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, len(roots), 2, "number of root files after snapshot of zip")
+}
+
+func testVfsCheckSeek(t *testing.T, fs FS, name string) {
+	f, err := fs.Open(name)
+	testutil.CheckErr(t, err)
+	defer f.Close()
+	content := testVfsFiles[name]
+	// read a little first, so we confirm that position is retained (e.g. when zip buffers).
+	bs := make([]byte, 2)
+	_, err = io.ReadFull(f, bs)
+	testutil.CheckErr(t, err)
+	_, err = f.(WithReadAt).ReadAt(bs, 4)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, string(bs), content[4:6], name+": ReadAt")
+	_, err = io.ReadFull(f, bs)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, string(bs), content[2:4], name+": Read after ReadAt")
+	_, err = f.(WithSeek).Seek(-3, io.SeekEnd)
+	testutil.CheckErr(t, err)
+	bs, err = io.ReadAll(f)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, string(bs), content[len(content)-3:], name+": Read after Seek")
+}
+
+func TestFileSeek(t *testing.T) {
+	dir := testVfsOsDir(t)
+	osfs, err := NewOsFS(dir)
+	testutil.CheckErr(t, err)
+	defer osfs.Close()
+	m, err := Snapshot(osfs)
+	testutil.CheckErr(t, err)
+
+	// write a zip with some entries stored and others deflated
+	zpath := filepath.Join(t.TempDir(), "test.zip")
+	zf, err := os.Create(zpath)
+	testutil.CheckErr(t, err)
+	zw := zip.NewWriter(zf)
+	for k, v := range testVfsFiles {
+		method := zip.Deflate
+		if k == "a.txt" {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: k, Method: method})
+		testutil.CheckErr(t, err)
+		_, err = io.WriteString(w, v)
+		testutil.CheckErr(t, err)
+	}
+	testutil.CheckErr(t, zw.Close())
+	testutil.CheckErr(t, zf.Close())
+	zrc, err := zip.OpenReader(zpath)
+	testutil.CheckErr(t, err)
+	zfs := NewZipFS(zrc)
+	defer zfs.Close()
+
+	for _, fs := range []FS{osfs, m, zfs} {
+		for k := range testVfsFiles {
+			testVfsCheckSeek(t, fs, k)
+		}
+	}
+}
+
+func TestZipChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "a.txt", Method: zip.Store})
+	testutil.CheckErr(t, err)
+	_, err = io.WriteString(w, "stored content")
+	testutil.CheckErr(t, err)
+	testutil.CheckErr(t, zw.Close())
+
+	// corrupt the stored content
+	zpath := filepath.Join(t.TempDir(), "test.zip")
+	testutil.CheckErr(t, os.WriteFile(zpath, bytes.Replace(buf.Bytes(), []byte("stored"), []byte("st0red"), 1), 0o644))
+	zrc, err := zip.OpenReader(zpath)
+	testutil.CheckErr(t, err)
+	zfs := NewZipFS(zrc)
+	defer zfs.Close()
+
+	f, err := zfs.Open("a.txt")
+	testutil.CheckErr(t, err)
+	_, err = io.ReadAll(f)
+	testutil.CheckEqual(t, err, zip.ErrChecksum, "sequential read")
+	// a read from the start after a seek is verified again
+	_, err = f.(WithSeek).Seek(0, io.SeekStart)
+	testutil.CheckErr(t, err)
+	_, err = io.ReadAll(f)
+	testutil.CheckEqual(t, err, zip.ErrChecksum, "read after seek to start")
+	// a read from the middle cannot be verified
+	_, err = f.(WithSeek).Seek(2, io.SeekStart)
+	testutil.CheckErr(t, err)
+	bs, err := io.ReadAll(f)
+	testutil.CheckErr(t, err)
+	testutil.CheckEqual(t, string(bs), "0red content", "read after seek")
+	f.Close()
+}
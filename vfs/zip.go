@@ -2,10 +2,13 @@ package vfs
 
 import (
 	"archive/zip"
+	"bytes"
+	"hash"
+	"hash/crc32"
 	"io"
+	"math"
 	"os"
 	"path"
-	"path/filepath"
 	"regexp"
 	"strings"
 )
@@ -25,11 +28,29 @@ type zipFileEntry struct {
 	z         *ZipFS
 }
 
+type readSeekerAt interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// zipFile is an open'ed entry in a zip file.
+//
+// Stored entries are read directly from the zip file, and so support Seek and ReadAt.
+// Their CRC-32 checksum is verified when they are read sequentially through to the end.
+// Deflated entries are streamed, until Seek or ReadAt is called,
+// at which point the whole entry is decompressed into memory.
 type zipFile struct {
 	*zipFileEntry
-	io.ReadCloser
+	rc  io.ReadCloser // streaming reader (nil once rs is set)
+	rs  readSeekerAt  // random access reader
+	pos int64         // number of bytes read from rc (or from rs, while crc is set)
+	crc hash.Hash32   // checksum of a stored entry read sequentially from the start (else nil)
 }
 
+// zipMaxPresize caps the memory reserved up front when buffering an entry,
+// since its uncompressed size is read from the (untrusted) zip header.
+const zipMaxPresize = 1 << 20
+
 func NewZipFS(r *zip.ReadCloser) (z *ZipFS) {
 	z = &ZipFS{r, make(map[string]*zipFileEntry, len(r.File))}
 	for _, f := range r.File {
@@ -52,13 +73,17 @@ func (x *ZipFS) Matches(matchRe, notMatchRe *regexp.Regexp, includeDirs bool) (n
 	return
 }
 
+// matchesInfo returns the entries directly under basepath ("" for the root) whose name matches pattern.
 func (x *ZipFS) matchesInfo(basepath, pattern string, n int) (infos []FileInfo, err error) {
 	var matches bool
 	var fi FileInfo
+	if basepath != "" {
+		basepath += "/"
+	}
 	for k, v := range x.m {
 		if basepath == "" || strings.HasPrefix(k, basepath) {
 			k = k[len(basepath):]
-			matches, err = filepath.Match(k, pattern)
+			matches, err = path.Match(pattern, k)
 			if err != nil {
 				return
 			}
@@ -86,15 +111,110 @@ func (x *ZipFS) Open(name string) (f File, err error) {
 	if !ok {
 		return nil, ErrInvalid
 	}
+	if z.Method == zip.Store {
+		// OpenRaw returns a section of the zip file, which supports random access.
+		// It does not verify the CRC-32 checksum, so Read does.
+		var r io.Reader
+		if r, err = z.OpenRaw(); err != nil {
+			return
+		}
+		if rs, ok := r.(readSeekerAt); ok {
+			zf := &zipFile{zipFileEntry: z, rs: rs}
+			if z.CRC32 != 0 {
+				zf.crc = crc32.NewIEEE()
+			}
+			return zf, nil
+		}
+	}
 	rc, err := z.Open()
 	if err != nil {
 		return
 	}
-	return &zipFile{z, rc}, nil
+	return &zipFile{zipFileEntry: z, rc: rc}, nil
+}
+
+func (x *zipFile) Read(p []byte) (n int, err error) {
+	if x.rs != nil {
+		n, err = x.rs.Read(p)
+		if x.crc != nil {
+			x.crc.Write(p[:n])
+			x.pos += int64(n)
+			if err == io.EOF && x.crc.Sum32() != x.CRC32 {
+				err = zip.ErrChecksum
+			}
+		}
+		return
+	}
+	n, err = x.rc.Read(p)
+	x.pos += int64(n)
+	return
+}
+
+func (x *zipFile) Close() (err error) {
+	if x.rc != nil {
+		err = x.rc.Close()
+	}
+	return
+}
+
+func (x *zipFile) Seek(offset int64, whence int) (n int64, err error) {
+	if err = x.buffer(); err != nil {
+		return
+	}
+	if n, err = x.rs.Seek(offset, whence); err == nil && x.crc != nil {
+		// verification continues only from the start, or from where reading stopped
+		if n == 0 {
+			x.crc.Reset()
+			x.pos = 0
+		} else if n != x.pos {
+			x.crc = nil
+		}
+	}
+	return
+}
+
+func (x *zipFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if err = x.buffer(); err != nil {
+		return
+	}
+	return x.rs.ReadAt(p, off)
+}
+
+// buffer decompresses the whole entry into memory, so it can support random access.
+// The current read position is retained.
+func (x *zipFile) buffer() (err error) {
+	if x.rs != nil {
+		return
+	}
+	rc, err := x.zipFileEntry.Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	if x.UncompressedSize64 >= math.MaxInt64 {
+		return zip.ErrFormat
+	}
+	size := int64(x.UncompressedSize64)
+	var buf bytes.Buffer
+	buf.Grow(int(min(size, zipMaxPresize)))
+	// the reader checks the size and checksum at the end; the limit guards against a bad size
+	if _, err = io.Copy(&buf, io.LimitReader(rc, size+1)); err != nil {
+		return
+	}
+	if int64(buf.Len()) > size {
+		return zip.ErrFormat
+	}
+	br := bytes.NewReader(buf.Bytes())
+	if _, err = br.Seek(x.pos, io.SeekStart); err != nil {
+		return
+	}
+	err = x.rc.Close()
+	x.rs, x.rc = br, nil
+	return
 }
 
 func (x *zipFileEntry) ReadDir(n int) (infos []FileInfo, err error) {
-	return x.z.matchesInfo(x.cleanName, "*", -1)
+	return x.z.matchesInfo(x.cleanName, "*", n)
 }
 
 func (x *zipFileEntry) Stat() (fi FileInfo, err error) {
@@ -102,3 +222,4 @@ func (x *zipFileEntry) Stat() (fi FileInfo, err error) {
 }
 
 var _, _, _ = FS((*ZipFS)(nil)), File((*zipFile)(nil)), FileInfo((os.FileInfo)(nil))
+var _, _ = WithSeek((*zipFile)(nil)), WithReadAt((*zipFile)(nil))